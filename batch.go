@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// BatchManifest writes the versions a restore would copy as an S3 Batch
+// Operations CSV manifest, as they're planned.
+type BatchManifest struct {
+	w       *csv.Writer
+	md5     hash.Hash
+	Objects int
+}
+
+func NewBatchManifest(w io.Writer) *BatchManifest {
+	h := md5.New()
+	return &BatchManifest{w: csv.NewWriter(io.MultiWriter(w, h)), md5: h}
+}
+
+// batchEscape URL encodes a key for a manifest. Spaces are encoded as %20
+// rather than +, so keys containing + survive either way of decoding them.
+func batchEscape(key string) string {
+	return strings.Replace(url.QueryEscape(key), "+", "%20", -1)
+}
+
+func (m *BatchManifest) Add(bucket string, version *s3.ObjectVersion) error {
+	m.Objects++
+	return m.w.Write([]string{bucket, batchEscape(*version.Key), *version.VersionId})
+}
+
+func (m *BatchManifest) Close() error {
+	m.w.Flush()
+	return m.w.Error()
+}
+
+// ETag returns the ETag S3 gives the manifest when it's uploaded in a single
+// part, which a job must be created with.
+func (m *BatchManifest) ETag() string {
+	return hex.EncodeToString(m.md5.Sum(nil))
+}
+
+// BatchJobOptions are what a Batch Operations job needs besides the bucket
+// and manifest. ManifestURL is where the manifest will be uploaded to and
+// ReportURL, if any, where the job writes its completion report, both as
+// s3://bucket/key.
+type BatchJobOptions struct {
+	AccountId   string
+	RoleArn     string
+	ManifestURL string
+	ReportURL   string
+}
+
+type batchJob struct {
+	AccountId            string
+	ClientRequestToken   string
+	ConfirmationRequired bool
+	Description          string
+	Priority             int
+	RoleArn              string
+	Operation            struct {
+		S3PutObjectCopy struct {
+			TargetResource string
+		}
+	}
+	Manifest struct {
+		Spec struct {
+			Format string
+			Fields []string
+		}
+		Location struct {
+			ObjectArn string
+			ETag      string
+		}
+	}
+	Report struct {
+		Enabled     bool
+		Bucket      string `json:",omitempty"`
+		Prefix      string `json:",omitempty"`
+		Format      string `json:",omitempty"`
+		ReportScope string `json:",omitempty"`
+	}
+}
+
+// WriteBatchJob writes the definition of a Batch Operations job copying every
+// version in a manifest with the given ETag over the current version of its
+// key, as taken by aws s3control create-job --cli-input-json. The job waits
+// for confirmation before running. Batch Operations only copies objects of
+// up to 5 GB.
+func WriteBatchJob(w io.Writer, bucket string, opts BatchJobOptions, manifestETag string) error {
+
+	manifestBucket, manifestKey, ok := splitS3URL(opts.ManifestURL)
+	if !ok {
+		return fmt.Errorf("invalid manifest location %q, must be s3://bucket/key", opts.ManifestURL)
+	}
+
+	job := batchJob{
+		AccountId:            opts.AccountId,
+		ClientRequestToken:   "s3r-" + manifestETag,
+		ConfirmationRequired: true,
+		Description:          "s3r restore of " + bucket,
+		Priority:             10,
+		RoleArn:              opts.RoleArn,
+	}
+	job.Operation.S3PutObjectCopy.TargetResource = "arn:aws:s3:::" + bucket
+	job.Manifest.Spec.Format = "S3BatchOperations_CSV_20180820"
+	job.Manifest.Spec.Fields = []string{"Bucket", "Key", "VersionId"}
+	job.Manifest.Location.ObjectArn = "arn:aws:s3:::" + manifestBucket + "/" + manifestKey
+	job.Manifest.Location.ETag = manifestETag
+
+	if opts.ReportURL != "" {
+		reportBucket, reportPrefix := "", ""
+		if strings.HasPrefix(opts.ReportURL, "s3://") {
+			parts := strings.SplitN(strings.TrimPrefix(opts.ReportURL, "s3://"), "/", 2)
+			reportBucket = parts[0]
+			if len(parts) == 2 {
+				reportPrefix = parts[1]
+			}
+		}
+		if reportBucket == "" {
+			return fmt.Errorf("invalid report location %q, must be s3://bucket/prefix", opts.ReportURL)
+		}
+		job.Report.Enabled = true
+		job.Report.Bucket = "arn:aws:s3:::" + reportBucket
+		job.Report.Prefix = reportPrefix
+		job.Report.Format = "Report_CSV_20180820"
+		job.Report.ReportScope = "AllTasks"
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(job)
+}
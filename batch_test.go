@@ -0,0 +1,79 @@
+package main_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Batch Operations", func() {
+
+	It("Writes a CSV manifest with URL encoded keys", func() {
+		var out bytes.Buffer
+		manifest := NewBatchManifest(&out)
+
+		Expect(manifest.Add("mybucket", version("dir/a file+1", "v1", 100, false))).To(Succeed())
+		Expect(manifest.Add("mybucket", version("b,c", "v2", 100, false))).To(Succeed())
+		Expect(manifest.Close()).To(Succeed())
+
+		Expect(out.String()).To(Equal("mybucket,dir%2Fa%20file%2B1,v1\nmybucket,b%2Cc,v2\n"))
+		Expect(manifest.Objects).To(Equal(2))
+		sum := md5.Sum(out.Bytes())
+		Expect(manifest.ETag()).To(Equal(hex.EncodeToString(sum[:])))
+	})
+
+	It("Writes a Copy job for the manifest", func() {
+		var out bytes.Buffer
+		err := WriteBatchJob(&out, "mybucket", BatchJobOptions{
+			AccountId:   "123456789012",
+			RoleArn:     "arn:aws:iam::123456789012:role/batch",
+			ManifestURL: "s3://manifests/restore.csv",
+			ReportURL:   "s3://reports/restores",
+		}, "abc")
+		Expect(err).ToNot(HaveOccurred())
+
+		var job map[string]interface{}
+		Expect(json.Unmarshal(out.Bytes(), &job)).To(Succeed())
+		Expect(job["AccountId"]).To(Equal("123456789012"))
+		Expect(job["RoleArn"]).To(Equal("arn:aws:iam::123456789012:role/batch"))
+		Expect(job["ConfirmationRequired"]).To(BeTrue())
+		Expect(job["Operation"]).To(Equal(map[string]interface{}{
+			"S3PutObjectCopy": map[string]interface{}{"TargetResource": "arn:aws:s3:::mybucket"},
+		}))
+		Expect(job["Manifest"]).To(Equal(map[string]interface{}{
+			"Spec": map[string]interface{}{
+				"Format": "S3BatchOperations_CSV_20180820",
+				"Fields": []interface{}{"Bucket", "Key", "VersionId"},
+			},
+			"Location": map[string]interface{}{
+				"ObjectArn": "arn:aws:s3:::manifests/restore.csv",
+				"ETag":      "abc",
+			},
+		}))
+		Expect(job["Report"]).To(HaveKeyWithValue("Bucket", "arn:aws:s3:::reports"))
+		Expect(job["Report"]).To(HaveKeyWithValue("Prefix", "restores"))
+		Expect(job["Report"]).To(HaveKeyWithValue("Enabled", true))
+	})
+
+	It("Disables the report unless asked for", func() {
+		var out bytes.Buffer
+		err := WriteBatchJob(&out, "mybucket", BatchJobOptions{ManifestURL: "s3://manifests/restore.csv"}, "abc")
+		Expect(err).ToNot(HaveOccurred())
+
+		var job map[string]interface{}
+		Expect(json.Unmarshal(out.Bytes(), &job)).To(Succeed())
+		Expect(job["Report"]).To(Equal(map[string]interface{}{"Enabled": false}))
+	})
+
+	It("Refuses manifest locations outside S3", func() {
+		var out bytes.Buffer
+		err := WriteBatchJob(&out, "mybucket", BatchJobOptions{ManifestURL: "/tmp/restore.csv"}, "abc")
+
+		Expect(err).To(MatchError(`invalid manifest location "/tmp/restore.csv", must be s3://bucket/key`))
+	})
+})
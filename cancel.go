@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// canceled returns a CanceledError once ctx is done.
+func canceled(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return &CanceledError{Err: err}
+	}
+	return nil
+}
+
+// send sends req, abandoning it once ctx is done, when it returns a
+// CanceledError. The SDK predates contexts, so ctx is set on the HTTP request
+// instead. Requests refused for lack of permission return a PermissionError.
+func send(ctx context.Context, req *request.Request) error {
+	if err := canceled(ctx); err != nil {
+		return err
+	}
+	req.HTTPRequest = req.HTTPRequest.WithContext(ctx)
+	// Waits between retries end early too.
+	req.Config.SleepDelay = func(d time.Duration) {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	if err := req.Send(); err != nil {
+		if err := canceled(ctx); err != nil {
+			return err
+		}
+		if ClassifyError(err) == ErrorPermission {
+			return &PermissionError{Err: err}
+		}
+		return err
+	}
+	return nil
+}
+
+// sendPages sends req and the requests for each page after it, calling fn
+// with each page's output until it returns false. Each page's request is new,
+// so each is given ctx.
+func sendPages(ctx context.Context, req *request.Request, fn func(data interface{}) bool) error {
+	for page := req; page != nil; page = page.NextPage() {
+		if err := send(ctx, page); err != nil {
+			return err
+		}
+		if !fn(page.Data) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// cancelOnSignal returns a context which is cancelled by the first SIGINT or
+// SIGTERM, so work in progress can be wound down. A second signal exits at
+// once.
+func cancelOnSignal() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		logger.Warn("stopping, letting copies in progress finish; signal again to exit at once", "signal", sig)
+		cancel()
+		sig = <-signals
+		logger.Error("exiting at once", "signal", sig)
+		os.Exit(ExitInterrupted)
+	}()
+	return ctx
+}
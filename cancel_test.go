@@ -0,0 +1,122 @@
+package main_test
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Cancellation", func() {
+	var (
+		fake *fakeS3
+		svc  *S3svc
+	)
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("a", "a2", 200, true),
+				version("a", "a1", 100, false),
+				version("b", "b2", 200, true),
+				version("b", "b1", 100, false),
+				version("c", "c2", 200, true),
+				version("c", "c1", 100, false),
+			},
+		}
+		svc = fake.S3svc()
+	})
+
+	It("Stops starting copies, but lets those in progress finish", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		fake.OnCopy = func(string) { cancel() }
+
+		err := svc.RestorePrefix(ctx, "mybucket", "", time.Unix(150, 0))
+
+		Expect(err).To(Equal(&CanceledError{Err: context.Canceled}))
+		Expect(fake.Copied).To(Equal([]string{"a1"}))
+		Expect(svc.Restored).To(HaveLen(1))
+		Expect(svc.Failures).To(BeEmpty())
+	})
+
+	It("Doesn't list once cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := svc.ListVersions(ctx, "mybucket", "")
+		Expect(err).To(Equal(&CanceledError{Err: context.Canceled}))
+		Expect(fake.Calls["ListObjectVersions"]).To(BeZero())
+
+		svc.ListParallelism = 4
+		_, err = svc.ListVersions(ctx, "mybucket", "")
+		Expect(err).To(Equal(&CanceledError{Err: context.Canceled}))
+		Expect(fake.Calls["ListObjectVersions"]).To(BeZero())
+	})
+
+	It("Doesn't retry requests once cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		fake.CopyFailures["a1"] = []int{500, 500}
+		fake.OnCopy = func(string) { cancel() }
+
+		_, err := svc.CopyObject(ctx, "mybucket", "a", "a1")
+		Expect(err).To(Equal(&CanceledError{Err: context.Canceled}))
+		Expect(fake.Calls["CopyObject"]).To(Equal(1))
+	})
+
+	It("Stops waiting to retry lookups once cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		svc.Svc.Retryer = &Retryer{NumMaxRetries: 3, BaseDelay: time.Hour, MaxDelay: time.Hour}
+		fake.Heads["a1"] = http.Header{}
+		fake.HeadFailures["a1"] = []int{500}
+		time.AfterFunc(50*time.Millisecond, cancel)
+
+		start := time.Now()
+		_, err := svc.HeadVersion(ctx, "mybucket", "a", "a1")
+		Expect(err).To(Equal(&CanceledError{Err: context.Canceled}))
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		Expect(fake.Calls["HeadObject"]).To(Equal(1))
+	})
+
+	It("Doesn't look up, fetch or delete versions once cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		fake.Heads["a1"] = http.Header{}
+
+		_, err := svc.HeadVersion(ctx, "mybucket", "a", "a1")
+		Expect(err).To(Equal(&CanceledError{Err: context.Canceled}))
+		_, err = svc.GetVersion(ctx, "mybucket", "a", "a1")
+		Expect(err).To(Equal(&CanceledError{Err: context.Canceled}))
+		_, err = svc.VersionTags(ctx, "mybucket", "a", "a1")
+		Expect(err).To(Equal(&CanceledError{Err: context.Canceled}))
+		_, err = svc.DeleteObject(ctx, "mybucket", "a")
+		Expect(err).To(Equal(&CanceledError{Err: context.Canceled}))
+		Expect(fake.Calls).To(BeEmpty())
+	})
+
+	It("Stops rollbacks and version restores between keys", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := svc.RollbackObjects(ctx, "mybucket", fake.Listing, time.Unix(150, 0), time.Unix(250, 0))
+		Expect(err).To(Equal(&CanceledError{Err: context.Canceled}))
+		fake.Heads["a1"] = http.Header{}
+		_, err = svc.RestoreVersions(ctx, "mybucket", []VersionRestore{{Key: "a", VersionId: "a1"}})
+		Expect(err).To(Equal(&CanceledError{Err: context.Canceled}))
+		Expect(fake.Copied).To(BeEmpty())
+	})
+
+	It("Stops verifying between keys", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := svc.VerifyBucket(ctx, "mybucket", fake.Listing, time.Unix(150, 0))
+		Expect(err).To(Equal(&CanceledError{Err: context.Canceled}))
+		Expect(fake.Calls["HeadObject"]).To(Equal(0))
+	})
+})
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxDiffCells bounds the work done comparing two texts line by line.
+const maxDiffCells = 25000000
+
+// VersionAt returns the ID of the version key had at t.
+func (s *S3svc) VersionAt(ctx context.Context, bucket, key string, t time.Time) (string, error) {
+
+	listVersionResp, err := s.ListVersions(ctx, bucket, key)
+	if err != nil {
+		return "", err
+	}
+	state, err := s.StateAt(ctx, bucket, listVersionResp, t)
+	if err != nil {
+		return "", err
+	}
+	version, ok := state[key]
+	if !ok {
+		return "", fmt.Errorf("%s didn't exist at %s", key, t)
+	}
+	return *version.VersionId, nil
+}
+
+// CatVersion writes the content of a version of key to w.
+func (s *S3svc) CatVersion(ctx context.Context, w io.Writer, bucket, key, version string) error {
+
+	getResp, err := s.GetVersion(ctx, bucket, key, version)
+	if err != nil {
+		return err
+	}
+	defer getResp.Body.Close()
+
+	_, err = io.Copy(w, getResp.Body)
+	return err
+}
+
+// readText reads a version for diffing. Its content is nil if it's binary.
+func (s *S3svc) readText(ctx context.Context, bucket, key, version string, maxSize int64) ([]byte, error) {
+
+	getResp, err := s.GetVersion(ctx, bucket, key, version)
+	if err != nil {
+		return nil, err
+	}
+	defer getResp.Body.Close()
+
+	if getResp.ContentLength != nil && *getResp.ContentLength > maxSize {
+		return nil, fmt.Errorf("version %s of %s is larger than %d bytes", version, key, maxSize)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(getResp.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxSize {
+		return nil, fmt.Errorf("version %s of %s is larger than %d bytes", version, key, maxSize)
+	}
+
+	if bytes.IndexByte(content, 0) >= 0 || !utf8.Valid(content) {
+		return nil, nil
+	}
+	return content, nil
+}
+
+// ContentDiff writes a unified diff between two versions of key to w.
+// Versions larger than maxSize are refused, binary ones are only compared.
+func (s *S3svc) ContentDiff(ctx context.Context, w io.Writer, bucket, key, fromVersion, toVersion string, maxSize int64) error {
+
+	from, err := s.readText(ctx, bucket, key, fromVersion, maxSize)
+	if err != nil {
+		return err
+	}
+	to, err := s.readText(ctx, bucket, key, toVersion, maxSize)
+	if err != nil {
+		return err
+	}
+
+	fromName := key + "?versionId=" + fromVersion
+	toName := key + "?versionId=" + toVersion
+	if from == nil || to == nil {
+		if fromVersion != toVersion {
+			fmt.Fprintf(w, "Binary versions %s and %s differ\n", fromName, toName)
+		}
+		return nil
+	}
+
+	diff, err := unifiedDiff(splitLines(string(from)), splitLines(string(to)), fromName, toName)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, diff)
+	return err
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+type diffLine struct {
+	op   byte
+	line string
+}
+
+// diffLines returns the edit script turning a into b, based on their longest
+// common subsequence.
+func diffLines(a, b []string) ([]diffLine, error) {
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var script []diffLine
+	for _, line := range a[:prefix] {
+		script = append(script, diffLine{' ', line})
+	}
+
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if len(ma)*len(mb) > maxDiffCells {
+		return nil, fmt.Errorf("versions are too different to compare")
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of ma[i:] and mb[j:].
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			script = append(script, diffLine{' ', ma[i]})
+			i++
+			j++
+		case j == len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+			script = append(script, diffLine{'-', ma[i]})
+			i++
+		default:
+			script = append(script, diffLine{'+', mb[j]})
+			j++
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		script = append(script, diffLine{' ', line})
+	}
+	return script, nil
+}
+
+// unifiedDiff formats the differences between a and b like diff -u, with
+// three lines of context.
+func unifiedDiff(a, b []string, fromName, toName string) (string, error) {
+	const context = 3
+
+	script, err := diffLines(a, b)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	for start := 0; start < len(script); {
+		if script[start].op == ' ' {
+			start++
+			continue
+		}
+
+		// Grow the hunk until changes are more than twice the context apart.
+		first := start - context
+		if first < 0 {
+			first = 0
+		}
+		end := start
+		for last := start; end < len(script); end++ {
+			if script[end].op != ' ' {
+				last = end
+			} else if end-last > 2*context {
+				break
+			}
+		}
+		last := end
+		for last > start && script[last-1].op == ' ' {
+			last--
+		}
+		if last += context; last > len(script) {
+			last = len(script)
+		}
+
+		// Line numbers of the hunk in a and b.
+		aStart, bStart := 1, 1
+		for _, line := range script[:first] {
+			if line.op != '+' {
+				aStart++
+			}
+			if line.op != '-' {
+				bStart++
+			}
+		}
+		aLines, bLines := 0, 0
+		for _, line := range script[first:last] {
+			if line.op != '+' {
+				aLines++
+			}
+			if line.op != '-' {
+				bLines++
+			}
+		}
+		if aLines == 0 {
+			aStart--
+		}
+		if bLines == 0 {
+			bStart--
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", aStart, aLines, bStart, bLines)
+		for _, line := range script[first:last] {
+			out.WriteByte(line.op)
+			out.WriteString(line.line)
+			if !strings.HasSuffix(line.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = last
+	}
+	return out.String(), nil
+}
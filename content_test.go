@@ -0,0 +1,86 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Object content", func() {
+	var (
+		fake *fakeS3
+		svc  *S3svc
+		out  bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("a", "v2", 300, true),
+				version("a", "v1", 100, false),
+				version("ab", "other", 200, true),
+			},
+			DeleteMarkers: []*s3.DeleteMarkerEntry{deleteMarker("a", "d1", 200, false)},
+		}
+		fake.Bodies["v1"] = "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+		fake.Bodies["v2"] = "one\nTWO\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven"
+		fake.Bodies["bin"] = "\x00\x01"
+		svc = fake.S3svc()
+		out.Reset()
+	})
+
+	It("Finds the version a key had at a point in time", func() {
+		Expect(svc.VersionAt(context.Background(), "mybucket", "a", time.Unix(150, 0))).To(Equal("v1"))
+		Expect(svc.VersionAt(context.Background(), "mybucket", "a", time.Unix(350, 0))).To(Equal("v2"))
+
+		_, err := svc.VersionAt(context.Background(), "mybucket", "a", time.Unix(250, 0))
+		Expect(err).To(MatchError(ContainSubstring("a didn't exist")))
+	})
+
+	It("Prints a version", func() {
+		Expect(svc.CatVersion(context.Background(), &out, "mybucket", "a", "v1")).To(Succeed())
+		Expect(out.String()).To(Equal(fake.Bodies["v1"]))
+	})
+
+	It("Prints a unified diff of two versions", func() {
+		Expect(svc.ContentDiff(context.Background(), &out, "mybucket", "a", "v1", "v2", 1024)).To(Succeed())
+		Expect(out.String()).To(Equal(`--- a?versionId=v1
++++ a?versionId=v2
+@@ -1,5 +1,5 @@
+ one
+-two
++TWO
+ three
+ four
+ five
+@@ -8,3 +8,4 @@
+ eight
+ nine
+ ten
++eleven
+\ No newline at end of file
+`))
+	})
+
+	It("Prints nothing for identical versions", func() {
+		Expect(svc.ContentDiff(context.Background(), &out, "mybucket", "a", "v1", "v1", 1024)).To(Succeed())
+		Expect(out.String()).To(BeEmpty())
+	})
+
+	It("Only compares binary versions", func() {
+		Expect(svc.ContentDiff(context.Background(), &out, "mybucket", "a", "v1", "bin", 1024)).To(Succeed())
+		Expect(out.String()).To(Equal("Binary versions a?versionId=v1 and a?versionId=bin differ\n"))
+	})
+
+	It("Refuses to compare large versions", func() {
+		Expect(svc.ContentDiff(context.Background(), &out, "mybucket", "a", "v1", "v2", 10)).To(MatchError(ContainSubstring("larger than 10 bytes")))
+	})
+
+})
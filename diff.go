@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// StateAt returns the version every key had at t, as SelectVersions picks
+// them. Keys which didn't exist at t, or had been deleted by then, are left
+// out.
+func (s *S3svc) StateAt(ctx context.Context, bucket string, versions *s3.ListObjectVersionsOutput, t time.Time) (map[string]*s3.ObjectVersion, error) {
+
+	selected, err := s.SelectVersions(ctx, bucket, versions, t)
+	if err != nil {
+		return nil, err
+	}
+
+	state := make(map[string]*s3.ObjectVersion)
+	for _, version := range selected {
+		state[*version.Key] = version
+	}
+	return state, nil
+}
+
+// KeyDiff describes how a key changed between two points in time. Change is
+// one of "added", "modified" or "deleted". From is nil for added keys and To
+// for deleted ones.
+type KeyDiff struct {
+	Key    string
+	Change string
+	From   *s3.ObjectVersion
+	To     *s3.ObjectVersion
+}
+
+// DiffBucket compares the state of the bucket at from with its state at to.
+func (s *S3svc) DiffBucket(ctx context.Context, bucket string, versions *s3.ListObjectVersionsOutput, from, to time.Time) ([]KeyDiff, error) {
+
+	before, err := s.StateAt(ctx, bucket, versions, from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := s.StateAt(ctx, bucket, versions, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []KeyDiff
+	for key, version := range after {
+		previous, ok := before[key]
+		if !ok {
+			diffs = append(diffs, KeyDiff{Key: key, Change: "added", To: version})
+		} else if *previous.VersionId != *version.VersionId {
+			diffs = append(diffs, KeyDiff{Key: key, Change: "modified", From: previous, To: version})
+		}
+	}
+	for key, previous := range before {
+		if _, ok := after[key]; !ok {
+			diffs = append(diffs, KeyDiff{Key: key, Change: "deleted", From: previous})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Key < diffs[j].Key
+	})
+	return diffs, nil
+}
+
+type diffVersion struct {
+	VersionId    string    `json:"version_id"`
+	Size         int64     `json:"size"`
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+type diffEntry struct {
+	Key    string       `json:"key"`
+	Change string       `json:"change"`
+	From   *diffVersion `json:"from,omitempty"`
+	To     *diffVersion `json:"to,omitempty"`
+}
+
+func newDiffVersion(version *s3.ObjectVersion) *diffVersion {
+	if version == nil {
+		return nil
+	}
+	v := &diffVersion{VersionId: *version.VersionId, LastModified: *version.LastModified}
+	if version.Size != nil {
+		v.Size = *version.Size
+	}
+	if version.ETag != nil {
+		v.ETag = *version.ETag
+	}
+	return v
+}
+
+func (v *diffVersion) fields() []string {
+	if v == nil {
+		return []string{"", "", ""}
+	}
+	return []string{v.VersionId, strconv.FormatInt(v.Size, 10), v.ETag}
+}
+
+// WriteDiffs writes diffs in format, one of "text", "json" or "csv".
+func WriteDiffs(w io.Writer, diffs []KeyDiff, format string) error {
+
+	entries := make([]diffEntry, 0, len(diffs))
+	for _, diff := range diffs {
+		entries = append(entries, diffEntry{
+			Key:    diff.Key,
+			Change: diff.Change,
+			From:   newDiffVersion(diff.From),
+			To:     newDiffVersion(diff.To),
+		})
+	}
+
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"key", "change", "from_version_id", "from_size", "from_etag", "to_version_id", "to_size", "to_etag"})
+		for _, entry := range entries {
+			record := append([]string{entry.Key, entry.Change}, entry.From.fields()...)
+			writer.Write(append(record, entry.To.fields()...))
+		}
+		writer.Flush()
+		return writer.Error()
+
+	case "text":
+		for _, entry := range entries {
+			from, to := entry.From.fields(), entry.To.fields()
+			switch entry.Change {
+			case "added":
+				fmt.Fprintf(w, "A %s\n  + %s size=%s etag=%s\n", entry.Key, to[0], to[1], to[2])
+			case "deleted":
+				fmt.Fprintf(w, "D %s\n  - %s size=%s etag=%s\n", entry.Key, from[0], from[1], from[2])
+			default:
+				fmt.Fprintf(w, "M %s\n  - %s size=%s etag=%s\n  + %s size=%s etag=%s\n",
+					entry.Key, from[0], from[1], from[2], to[0], to[1], to[2])
+			}
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("%q is not a valid format", format)
+	}
+}
@@ -0,0 +1,81 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Diff", func() {
+	var (
+		fake     *fakeS3
+		versions *s3.ListObjectVersionsOutput
+	)
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+		versions = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("added", "n1", 250, true),
+				version("deleted", "d1", 100, false),
+				version("modified", "m2", 250, true),
+				version("modified", "m1", 100, false),
+				version("same", "s1", 100, true),
+			},
+			DeleteMarkers: []*s3.DeleteMarkerEntry{deleteMarker("deleted", "dm", 250, true)},
+		}
+		versions.Versions[2].ETag = aws.String(`"m2"`)
+	})
+
+	It("Reports added, modified and deleted keys", func() {
+		diffs, err := fake.S3svc().DiffBucket(context.Background(), "mybucket", versions, time.Unix(200, 0), time.Unix(300, 0))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(diffs).To(HaveLen(3))
+		Expect(diffs[0]).To(Equal(KeyDiff{Key: "added", Change: "added", To: versions.Versions[0]}))
+		Expect(diffs[1]).To(Equal(KeyDiff{Key: "deleted", Change: "deleted", From: versions.Versions[1]}))
+		Expect(diffs[2]).To(Equal(KeyDiff{Key: "modified", Change: "modified", From: versions.Versions[3], To: versions.Versions[2]}))
+	})
+
+	It("Agrees with restore and verify about keys deleted by then", func() {
+		svc := fake.S3svc()
+		state, err := svc.StateAt(context.Background(), "mybucket", versions, time.Unix(300, 0))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(state).ToNot(HaveKey("deleted"))
+
+		differences, err := svc.VerifyBucket(context.Background(), "mybucket", versions, time.Unix(300, 0))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(differences).To(BeEmpty())
+
+		Expect(svc.RestoreObjects(context.Background(), "mybucket", versions, time.Unix(300, 0))).To(Succeed())
+		Expect(fake.Copied).To(BeEmpty())
+	})
+
+	It("Writes diffs as text, JSON and CSV", func() {
+		diffs, err := fake.S3svc().DiffBucket(context.Background(), "mybucket", versions, time.Unix(200, 0), time.Unix(300, 0))
+		Expect(err).ToNot(HaveOccurred())
+
+		var out bytes.Buffer
+		Expect(WriteDiffs(&out, diffs, "text")).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("M modified\n  - m1 size=10 etag=\n  + m2 size=10 etag=\"m2\"\n"))
+
+		out.Reset()
+		Expect(WriteDiffs(&out, diffs, "json")).To(Succeed())
+		Expect(out.String()).To(ContainSubstring(`"change": "deleted"`))
+		Expect(out.String()).To(ContainSubstring(`"version_id": "n1"`))
+
+		out.Reset()
+		Expect(WriteDiffs(&out, diffs, "csv")).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("added,added,,,,n1,10,\n"))
+
+		Expect(WriteDiffs(&out, diffs, "yaml")).ToNot(Succeed())
+	})
+
+})
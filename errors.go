@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Exit statuses, see ExitCode.
+const (
+	ExitFailed       = 1
+	ExitUsage        = 2
+	ExitPermission   = 3
+	ExitNotVersioned = 4
+	ExitPartial      = 5
+	ExitMismatch     = 6
+	ExitAborted      = 7
+	// ExitInterrupted is what a shell reports for a process killed by
+	// SIGINT.
+	ExitInterrupted = 130
+)
+
+// UsageError is a command line which doesn't make sense.
+type UsageError struct {
+	Message string
+}
+
+func (e *UsageError) Error() string {
+	return e.Message
+}
+
+func usageError(err error) error {
+	return &UsageError{Message: err.Error()}
+}
+
+// PermissionError is a request refused because the credentials used aren't
+// valid or aren't allowed to make it.
+type PermissionError struct {
+	Err error
+}
+
+func (e *PermissionError) Error() string {
+	return e.Err.Error()
+}
+
+// NotVersionedError is a bucket which can't be restored because versioning
+// isn't enabled on it. Status is "Suspended", or empty if versioning was
+// never enabled.
+type NotVersionedError struct {
+	Bucket string
+	Status string
+}
+
+func (e *NotVersionedError) Error() string {
+	if e.Status == "" {
+		return fmt.Sprintf("bucket %s isn't versioned", e.Bucket)
+	}
+	return fmt.Sprintf("versioning is %s on bucket %s", e.Status, e.Bucket)
+}
+
+// PartialRestoreError is a restore in which some objects couldn't be
+// restored. They're listed in S3svc.Failures.
+type PartialRestoreError struct {
+	Failed int
+	Total  int
+}
+
+func (e *PartialRestoreError) Error() string {
+	return fmt.Sprintf("%d of %d objects couldn't be restored", e.Failed, e.Total)
+}
+
+// ConflictError is keys a rollback left alone because they were changed
+// both inside and after its window. See RollbackObjects.
+type ConflictError struct {
+	Keys []string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%d keys weren't rolled back", len(e.Keys))
+}
+
+// VerificationError is restored objects which don't match the versions they
+// were restored from, or keys which don't match a point in time.
+type VerificationError struct {
+	Mismatches  []Mismatch
+	Differences []Difference
+}
+
+func (e *VerificationError) Error() string {
+	if len(e.Differences) > 0 {
+		return fmt.Sprintf("%d keys don't match", len(e.Differences))
+	}
+	return fmt.Sprintf("%d restored objects don't match", len(e.Mismatches))
+}
+
+// AbortedError is a restore stopped before anything was copied because its
+// plan was larger than allowed or wasn't confirmed.
+type AbortedError struct {
+	Reason string
+}
+
+func (e *AbortedError) Error() string {
+	return "aborted: " + e.Reason
+}
+
+// CanceledError is work stopped before it was done, see cancelOnSignal.
+type CanceledError struct {
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	return "interrupted: " + e.Err.Error()
+}
+
+// ExitCode returns the status s3r exits with after err.
+func ExitCode(err error) int {
+	switch err.(type) {
+	case nil:
+		return 0
+	case *UsageError:
+		return ExitUsage
+	case *PermissionError:
+		return ExitPermission
+	case *NotVersionedError:
+		return ExitNotVersioned
+	case *PartialRestoreError, *ConflictError:
+		return ExitPartial
+	case *VerificationError:
+		return ExitMismatch
+	case *AbortedError:
+		return ExitAborted
+	case *CanceledError:
+		return ExitInterrupted
+	}
+	return ExitFailed
+}
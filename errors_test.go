@@ -0,0 +1,79 @@
+package main_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Errors", func() {
+	var (
+		fake *fakeS3
+		svc  *S3svc
+	)
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("a", "a2", 200, true),
+				version("a", "a1", 100, false),
+				version("b", "b2", 200, true),
+				version("b", "b1", 100, false),
+			},
+		}
+		svc = fake.S3svc()
+	})
+
+	It("Maps errors to exit statuses", func() {
+		Expect(ExitCode(nil)).To(Equal(0))
+		Expect(ExitCode(errors.New("failed"))).To(Equal(ExitFailed))
+		Expect(ExitCode(&UsageError{Message: "bad"})).To(Equal(ExitUsage))
+		Expect(ExitCode(&PermissionError{Err: errors.New("denied")})).To(Equal(ExitPermission))
+		Expect(ExitCode(&NotVersionedError{Bucket: "mybucket"})).To(Equal(ExitNotVersioned))
+		Expect(ExitCode(&PartialRestoreError{Failed: 1, Total: 2})).To(Equal(ExitPartial))
+		Expect(ExitCode(&ConflictError{Keys: []string{"a"}})).To(Equal(ExitPartial))
+		Expect(ExitCode(&VerificationError{})).To(Equal(ExitMismatch))
+		Expect(ExitCode(&CanceledError{Err: context.Canceled})).To(Equal(ExitInterrupted))
+	})
+
+	It("Refuses to restore buckets which aren't versioned", func() {
+		fake.Versioning = ""
+		err := svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))
+		Expect(err).To(Equal(&NotVersionedError{Bucket: "mybucket"}))
+		Expect(err).To(MatchError("bucket mybucket isn't versioned"))
+		Expect(fake.Calls["ListObjectVersions"]).To(Equal(0))
+
+		fake.Versioning = "Suspended"
+		_, err = svc.RollbackObjects(context.Background(), "mybucket", fake.Listing, time.Unix(50, 0), time.Unix(150, 0))
+		Expect(err).To(MatchError("versioning is Suspended on bucket mybucket"))
+		Expect(fake.Copied).To(BeEmpty())
+	})
+
+	It("Restores anyway if it can't check versioning", func() {
+		fake.VersioningDenied = true
+		Expect(svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))).To(Succeed())
+		Expect(fake.Copied).To(ConsistOf("a1", "b1"))
+	})
+
+	It("Reports partial restores", func() {
+		fake.CopyFailures["b1"] = []int{403}
+		err := svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))
+		Expect(err).To(Equal(&PartialRestoreError{Failed: 1, Total: 2}))
+		Expect(svc.Failures).To(HaveLen(1))
+	})
+
+	It("Reports refused requests as permission errors", func() {
+		fake.Heads["a1"] = http.Header{}
+		fake.HeadFailures["a1"] = []int{403}
+		_, err := svc.RestoreVersions(context.Background(), "mybucket", []VersionRestore{{Key: "a", VersionId: "a1"}})
+		Expect(ExitCode(err)).To(Equal(ExitPermission))
+	})
+})
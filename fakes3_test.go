@@ -0,0 +1,303 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/awstesting/unit"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var versionIDRegexp = regexp.MustCompile(".*?versionId=")
+
+// fakeS3 answers the S3 calls made by S3svc from canned data, keyed by
+// version ID, and records what was asked of it.
+type fakeS3 struct {
+	Listing  *s3.ListObjectVersionsOutput
+	PageSize int
+
+	Heads  map[string]http.Header
+	Tags   map[string]map[string]string
+	Bodies map[string]string
+
+	// Versioning is the bucket's versioning status, empty if it was never
+	// enabled, unless VersioningDenied refuses to say.
+	Versioning       string
+	VersioningDenied bool
+
+	// CopyFailures are the statuses copies of a version fail with, one per
+	// attempt, before it's copied.
+	CopyFailures map[string][]int
+	// HeadFailures are the statuses HEAD requests for a version fail with,
+	// one per attempt, before it's found.
+	HeadFailures map[string][]int
+	// OnCopy, if set, is called with the version each copy attempt is of.
+	OnCopy func(version string)
+
+	Copied  []string
+	Deleted []string
+	Calls   map[string]int
+
+	lock sync.Mutex
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{
+		Heads:  map[string]http.Header{},
+		Tags:   map[string]map[string]string{},
+		Bodies: map[string]string{},
+		Calls:  map[string]int{},
+
+		Versioning:   "Enabled",
+		CopyFailures: map[string][]int{},
+		HeadFailures: map[string][]int{},
+	}
+}
+
+var errorCodes = map[int]string{
+	400: "InvalidRequest",
+	403: "AccessDenied",
+	500: "InternalError",
+	503: "SlowDown",
+}
+
+func (f *fakeS3) S3svc() *S3svc {
+	s := s3.New(unit.Session)
+
+	s.Handlers.Send.Clear()
+	s.Handlers.Send.PushBack(func(r *request.Request) {
+		// Like the HTTP client, refuse requests whose context is done.
+		if err := r.HTTPRequest.Context().Err(); err != nil {
+			r.Error = awserr.New("RequestError", "send request failed", err)
+			return
+		}
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		f.Calls[r.Operation.Name]++
+
+		status, header, body := 200, http.Header{}, ""
+		switch params := r.Params.(type) {
+		case *s3.CopyObjectInput:
+			copied := versionIDRegexp.ReplaceAllString(*params.CopySource, "")
+			if f.OnCopy != nil {
+				f.OnCopy(copied)
+			}
+			if failures := f.CopyFailures[copied]; len(failures) > 0 {
+				f.CopyFailures[copied] = failures[1:]
+				status = failures[0]
+				body = "<Error><Code>" + errorCodes[status] + "</Code><Message>failed</Message></Error>"
+				break
+			}
+			f.Copied = append(f.Copied, copied)
+			header.Set("X-Amz-Version-Id", "copy-of-"+copied)
+			// Copies look like their source unless a test says otherwise.
+			if _, ok := f.Heads["copy-of-"+copied]; !ok && f.Heads[copied] != nil {
+				f.Heads["copy-of-"+copied] = f.Heads[copied]
+			}
+			if _, ok := f.Bodies["copy-of-"+copied]; !ok {
+				f.Bodies["copy-of-"+copied] = f.Bodies[copied]
+			}
+			body = `<CopyObjectResult><ETag>` + *params.Key + `</ETag></CopyObjectResult>`
+		case *s3.DeleteObjectInput:
+			f.Deleted = append(f.Deleted, *params.Key)
+			status = 204
+		case *s3.HeadObjectInput:
+			if failures := f.HeadFailures[*params.VersionId]; len(failures) > 0 {
+				f.HeadFailures[*params.VersionId] = failures[1:]
+				status = failures[0]
+				break
+			}
+			if h, ok := f.Heads[*params.VersionId]; ok {
+				header = h
+				// S3 doesn't allow HEAD requests of delete markers.
+				if h.Get("X-Amz-Delete-Marker") == "true" {
+					status = 405
+				}
+			} else {
+				status = 404
+			}
+		case *s3.GetObjectInput:
+			// Objects fetched without a version are keyed by bucket/key.
+			if params.VersionId != nil {
+				body = f.Bodies[*params.VersionId]
+			} else {
+				body = f.Bodies[*params.Bucket+"/"+*params.Key]
+			}
+		case *s3.GetBucketVersioningInput:
+			if f.VersioningDenied {
+				status = 403
+				body = "<Error><Code>AccessDenied</Code><Message>denied</Message></Error>"
+				break
+			}
+			body = "<VersioningConfiguration>"
+			if f.Versioning != "" {
+				body += "<Status>" + f.Versioning + "</Status>"
+			}
+			body += "</VersioningConfiguration>"
+		case *s3.ListObjectVersionsInput:
+			body = f.listVersions(params)
+		case *s3.ListObjectsV2Input:
+			body = f.listObjects(params)
+		default:
+			// GetObjectTagging has no SDK type to switch on.
+			body = "<Tagging><TagSet>"
+			for key, value := range f.Tags[r.HTTPRequest.URL.Query().Get("versionId")] {
+				body += fmt.Sprintf("<Tag><Key>%s</Key><Value>%s</Value></Tag>", key, value)
+			}
+			body += "</TagSet></Tagging>"
+		}
+
+		r.HTTPResponse = &http.Response{
+			StatusCode: status,
+			Header:     header,
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(body))),
+		}
+	})
+
+	// Retry like NewS3svc does, without waiting.
+	limiter := NewLimiter(1)
+	s.Retryer = &Retryer{NumMaxRetries: 3, Limiter: limiter}
+
+	return &S3svc{Svc: s, Limiter: limiter}
+}
+
+// listVersions renders a page of Listing the way S3 does: sorted by key,
+// most recent change first, with versions and delete markers interleaved.
+// Keys containing the delimiter after the prefix are rolled up into common
+// prefixes.
+func (f *fakeS3) listVersions(params *s3.ListObjectVersionsInput) string {
+	type entry struct {
+		key, versionId string
+		lastModified   time.Time
+		xml            string
+	}
+	esc := func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	ts := func(t *time.Time) string {
+		return t.UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+
+	var entries []entry
+	if f.Listing != nil {
+		for _, v := range f.Listing.Versions {
+			x := fmt.Sprintf("<Version><Key>%s</Key><VersionId>%s</VersionId><IsLatest>%t</IsLatest><LastModified>%s</LastModified>",
+				esc(*v.Key), *v.VersionId, *v.IsLatest, ts(v.LastModified))
+			if v.ETag != nil {
+				x += "<ETag>" + esc(*v.ETag) + "</ETag>"
+			}
+			if v.Size != nil {
+				x += fmt.Sprintf("<Size>%d</Size>", *v.Size)
+			}
+			if v.StorageClass != nil {
+				x += "<StorageClass>" + *v.StorageClass + "</StorageClass>"
+			}
+			entries = append(entries, entry{*v.Key, *v.VersionId, *v.LastModified, x + "</Version>"})
+		}
+		for _, m := range f.Listing.DeleteMarkers {
+			x := fmt.Sprintf("<DeleteMarker><Key>%s</Key><VersionId>%s</VersionId><IsLatest>%t</IsLatest><LastModified>%s</LastModified></DeleteMarker>",
+				esc(*m.Key), *m.VersionId, *m.IsLatest, ts(m.LastModified))
+			entries = append(entries, entry{*m.Key, *m.VersionId, *m.LastModified, x})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].key != entries[j].key {
+			return entries[i].key < entries[j].key
+		}
+		return entries[i].lastModified.After(entries[j].lastModified)
+	})
+
+	prefix, delimiter := aws.StringValue(params.Prefix), aws.StringValue(params.Delimiter)
+	keyMarker, versionIdMarker := aws.StringValue(params.KeyMarker), aws.StringValue(params.VersionIdMarker)
+	maxKeys := int(aws.Int64Value(params.MaxKeys))
+	if maxKeys == 0 {
+		maxKeys = f.PageSize
+	}
+	if maxKeys == 0 {
+		maxKeys = 1000
+	}
+
+	var page []entry
+	truncated, started := false, keyMarker == ""
+	for _, e := range entries {
+		if !strings.HasPrefix(e.key, prefix) {
+			continue
+		}
+		if i := strings.Index(e.key[len(prefix):], delimiter); delimiter != "" && i >= 0 {
+			commonPrefix := e.key[:len(prefix)+i+len(delimiter)]
+			if commonPrefix <= keyMarker || len(page) > 0 && page[len(page)-1].key == commonPrefix {
+				continue
+			}
+			if len(page) == maxKeys {
+				truncated = true
+				break
+			}
+			started = true
+			page = append(page, entry{key: commonPrefix, xml: "<CommonPrefixes><Prefix>" + esc(commonPrefix) + "</Prefix></CommonPrefixes>"})
+			continue
+		}
+		if !started {
+			if e.key < keyMarker || (e.key == keyMarker && versionIdMarker == "") {
+				continue
+			}
+			if e.key == keyMarker {
+				if e.versionId == versionIdMarker {
+					started = true
+				}
+				continue
+			}
+			started = true
+		}
+		if len(page) == maxKeys {
+			truncated = true
+			break
+		}
+		page = append(page, e)
+	}
+
+	body := "<ListVersionsResult><Prefix>" + esc(prefix) + "</Prefix><Delimiter>" + esc(delimiter) + "</Delimiter>"
+	body += fmt.Sprintf("<IsTruncated>%t</IsTruncated>", truncated)
+	if truncated {
+		last := page[len(page)-1]
+		body += "<NextKeyMarker>" + esc(last.key) + "</NextKeyMarker><NextVersionIdMarker>" + last.versionId + "</NextVersionIdMarker>"
+	}
+	for _, e := range page {
+		body += e.xml
+	}
+	return body + "</ListVersionsResult>"
+}
+
+// listObjects renders the latest versions in Listing under the prefix as a
+// single page.
+func (f *fakeS3) listObjects(params *s3.ListObjectsV2Input) string {
+	var b bytes.Buffer
+	b.WriteString("<ListBucketResult><IsTruncated>false</IsTruncated>")
+	if f.Listing != nil {
+		for _, v := range f.Listing.Versions {
+			if *v.IsLatest && strings.HasPrefix(*v.Key, aws.StringValue(params.Prefix)) {
+				b.WriteString("<Contents><Key>")
+				xml.EscapeText(&b, []byte(*v.Key))
+				fmt.Fprintf(&b, "</Key><LastModified>%s</LastModified><ETag>", v.LastModified.UTC().Format("2006-01-02T15:04:05.000Z"))
+				xml.EscapeText(&b, []byte(aws.StringValue(v.ETag)))
+				fmt.Fprintf(&b, "</ETag><Size>%d</Size></Contents>", aws.Int64Value(v.Size))
+			}
+		}
+	}
+	b.WriteString("</ListBucketResult>")
+	return b.String()
+}
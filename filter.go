@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// VersionFilter recognises versions that must not be restored, e.g. the
+// bad writes that caused the restore in the first place.
+type VersionFilter interface {
+	Skip(ctx context.Context, s *S3svc, bucket string, version *s3.ObjectVersion) (bool, error)
+}
+
+// SizeFilter skips versions smaller than MinSize bytes.
+type SizeFilter struct {
+	MinSize int64
+}
+
+func (f SizeFilter) Skip(ctx context.Context, s *S3svc, bucket string, version *s3.ObjectVersion) (bool, error) {
+	return version.Size != nil && *version.Size < f.MinSize, nil
+}
+
+// ContentTypeFilter skips versions stored with one of ContentTypes.
+type ContentTypeFilter struct {
+	ContentTypes []string
+}
+
+func (f ContentTypeFilter) Skip(ctx context.Context, s *S3svc, bucket string, version *s3.ObjectVersion) (bool, error) {
+	head, err := s.HeadVersion(ctx, bucket, *version.Key, *version.VersionId)
+	if err != nil {
+		return false, err
+	}
+	if head.ContentType == nil {
+		return false, nil
+	}
+	for _, contentType := range f.ContentTypes {
+		if strings.EqualFold(*head.ContentType, contentType) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MetadataFilter skips versions whose user metadata (x-amz-meta-*) has Key
+// set to Value.
+type MetadataFilter struct {
+	Key   string
+	Value string
+}
+
+func (f MetadataFilter) Skip(ctx context.Context, s *S3svc, bucket string, version *s3.ObjectVersion) (bool, error) {
+	head, err := s.HeadVersion(ctx, bucket, *version.Key, *version.VersionId)
+	if err != nil {
+		return false, err
+	}
+	for key, value := range head.Metadata {
+		// The SDK canonicalises header names, so compare keys case-insensitively.
+		if strings.EqualFold(key, f.Key) && value != nil && *value == f.Value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// TagFilter skips versions tagged with Key set to Value.
+type TagFilter struct {
+	Key   string
+	Value string
+}
+
+func (f TagFilter) Skip(ctx context.Context, s *S3svc, bucket string, version *s3.ObjectVersion) (bool, error) {
+	tags, err := s.VersionTags(ctx, bucket, *version.Key, *version.VersionId)
+	if err != nil {
+		return false, err
+	}
+	for _, tag := range tags {
+		if *tag.Key == f.Key && *tag.Value == f.Value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SkipVersion reports whether any of the configured filters rejects version.
+// Cheap filters should come first, as later ones are not consulted once a
+// version has been rejected.
+func (s *S3svc) SkipVersion(ctx context.Context, bucket string, version *s3.ObjectVersion) (bool, error) {
+	for _, filter := range s.Filters {
+		skip, err := filter.Skip(ctx, s, bucket, version)
+		if err != nil {
+			return false, err
+		}
+		if skip {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func parseKeyValue(pair string) (string, string, error) {
+	parts := strings.SplitN(pair, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", fmt.Errorf("%q is not in key=value format", pair)
+	}
+	return parts[0], parts[1], nil
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// addFilterFlags adds the version filter options to command. The returned
+// function copies their values into the parsed arguments.
+func addFilterFlags(command *flag.FlagSet) func(args map[string]string) map[string]string {
+	skipEmpty := command.Bool("skip-empty", false, "Skip zero-byte versions when choosing the version to restore.")
+	skipContentType := command.String("skip-content-type", "", "Comma-separated content types of versions to skip. Default none.")
+	skipMetadata := command.String("skip-metadata", "", "Comma-separated key=value user metadata of versions to skip. Default none.")
+	skipTag := command.String("skip-tag", "", "Comma-separated key=value tags of versions to skip. Default none.")
+
+	return func(args map[string]string) map[string]string {
+		args["skip-empty"] = strconv.FormatBool(*skipEmpty)
+		args["skip-content-type"] = *skipContentType
+		args["skip-metadata"] = *skipMetadata
+		args["skip-tag"] = *skipTag
+		return args
+	}
+}
+
+// parseFilters builds the version filters requested on the command line.
+func parseFilters(args map[string]string) ([]VersionFilter, error) {
+	var filters []VersionFilter
+
+	if args["skip-empty"] == "true" {
+		filters = append(filters, SizeFilter{MinSize: 1})
+	}
+	if contentTypes := splitList(args["skip-content-type"]); len(contentTypes) > 0 {
+		filters = append(filters, ContentTypeFilter{ContentTypes: contentTypes})
+	}
+	for _, pair := range splitList(args["skip-metadata"]) {
+		key, value, err := parseKeyValue(pair)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, MetadataFilter{Key: key, Value: value})
+	}
+	for _, pair := range splitList(args["skip-tag"]) {
+		key, value, err := parseKeyValue(pair)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, TagFilter{Key: key, Value: value})
+	}
+	return filters, nil
+}
@@ -0,0 +1,81 @@
+package main_test
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Version filters", func() {
+	var (
+		fake     *fakeS3
+		svc      *S3svc
+		versions *s3.ListObjectVersionsOutput
+	)
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+		svc = fake.S3svc()
+
+		vs := defaultVersions()
+		for _, v := range vs {
+			v.Size = aws.Int64(10)
+			fake.Heads[*v.VersionId] = http.Header{"Content-Type": []string{"text/plain"}}
+		}
+		versions = &s3.ListObjectVersionsOutput{Versions: vs}
+	})
+
+	It("Skips zero-byte versions", func() {
+		versions.Versions[1].Size = aws.Int64(0)
+		svc.Filters = []VersionFilter{SizeFilter{MinSize: 1}}
+
+		Expect(svc.RestoreObjects(context.Background(), "mybucket", versions, time.Unix(250, 0))).To(Succeed())
+		Expect(fake.Copied).To(Equal([]string{"v1"}))
+		Expect(fake.Calls["HeadObject"]).To(Equal(0))
+	})
+
+	It("Skips versions with unwanted content type", func() {
+		fake.Heads["v2"].Set("Content-Type", "application/octet-stream")
+		svc.Filters = []VersionFilter{ContentTypeFilter{ContentTypes: []string{"application/octet-stream"}}}
+
+		Expect(svc.RestoreObjects(context.Background(), "mybucket", versions, time.Unix(250, 0))).To(Succeed())
+		Expect(fake.Copied).To(Equal([]string{"v1"}))
+	})
+
+	It("Skips versions with matching metadata and caches lookups", func() {
+		fake.Heads["v2"].Set("X-Amz-Meta-Writer", "deploy-42")
+		svc.Filters = []VersionFilter{
+			MetadataFilter{Key: "writer", Value: "deploy-42"},
+			ContentTypeFilter{ContentTypes: []string{"image/png"}},
+		}
+
+		Expect(svc.RestoreObjects(context.Background(), "mybucket", versions, time.Unix(250, 0))).To(Succeed())
+		Expect(fake.Copied).To(Equal([]string{"v1"}))
+		Expect(fake.Calls["HeadObject"]).To(Equal(2))
+	})
+
+	It("Skips versions with matching tags", func() {
+		fake.Tags["v2"] = map[string]string{"quarantine": "true"}
+		svc.Filters = []VersionFilter{TagFilter{Key: "quarantine", Value: "true"}}
+
+		Expect(svc.RestoreObjects(context.Background(), "mybucket", versions, time.Unix(250, 0))).To(Succeed())
+		Expect(fake.Copied).To(Equal([]string{"v1"}))
+		Expect(fake.Calls["GetObjectTagging"]).To(Equal(2))
+	})
+
+	It("Fails when metadata can't be looked up", func() {
+		delete(fake.Heads, "v2")
+		svc.Filters = []VersionFilter{MetadataFilter{Key: "writer", Value: "deploy-42"}}
+
+		Expect(svc.RestoreObjects(context.Background(), "mybucket", versions, time.Unix(250, 0))).ToNot(Succeed())
+		Expect(fake.Copied).To(BeEmpty())
+	})
+
+})
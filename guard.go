@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// PlanSummary is what a restore is about to do, shown when asking for
+// confirmation. Command is empty for restores, otherwise rollback, with From
+// the start of its window, or restore-version. Objects counts Deletes too.
+type PlanSummary struct {
+	Command   string
+	Bucket    string
+	Prefix    string
+	From      time.Time
+	Timestamp time.Time
+	Objects   int
+	Bytes     int64
+	Deletes   int
+	Protected []string
+}
+
+func (p PlanSummary) Text() string {
+	var text string
+	switch p.Command {
+	case "rollback":
+		text = fmt.Sprintf("Roll back s3://%s/%s from %s to %s", p.Bucket, p.Prefix,
+			p.From.UTC().Format(time.RFC3339), p.Timestamp.UTC().Format(time.RFC3339))
+	case "restore-version":
+		text = fmt.Sprintf("Restore versions in s3://%s/", p.Bucket)
+	default:
+		text = fmt.Sprintf("Restore s3://%s/%s to %s", p.Bucket, p.Prefix, p.Timestamp.UTC().Format(time.RFC3339))
+	}
+	text += fmt.Sprintf(": %d objects, %s to copy", p.Objects, humanBytes(float64(p.Bytes)))
+	if p.Deletes > 0 {
+		text += fmt.Sprintf(", %d to delete", p.Deletes)
+	}
+	if len(p.Protected) > 0 {
+		text += fmt.Sprintf(", leaving keys under %s alone", strings.Join(p.Protected, ", "))
+	}
+	return text
+}
+
+// Prompt returns a confirmation which writes the summary of a plan to out and
+// reads the answer from in. Only y or yes goes ahead.
+func Prompt(in io.Reader, out io.Writer) func(PlanSummary) (bool, error) {
+	reader := bufio.NewReader(in)
+	return func(summary PlanSummary) (bool, error) {
+		fmt.Fprintf(out, "%s\nContinue? [y/N] ", summary.Text())
+		answer, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			return true, nil
+		}
+		return false, nil
+	}
+}
+
+// guarded reports whether a restore must be planned in full before anything
+// is copied, to check it against the limits or ask for confirmation.
+func (s *S3svc) guarded() bool {
+	return s.MaxChanges > 0 || s.MaxBytes > 0 || s.Confirm != nil
+}
+
+// checkLimits returns an AbortedError once a plan of that many objects,
+// adding up to bytes, is larger than MaxChanges or MaxBytes allow.
+func (s *S3svc) checkLimits(objects int, bytes int64) error {
+	if s.MaxChanges > 0 && objects > s.MaxChanges {
+		return &AbortedError{Reason: fmt.Sprintf("more than %d objects would be restored (-max-changes)", s.MaxChanges)}
+	}
+	if s.MaxBytes > 0 && bytes > s.MaxBytes {
+		return &AbortedError{Reason: fmt.Sprintf("more than %s would be copied (-max-bytes)", humanBytes(float64(s.MaxBytes)))}
+	}
+	return nil
+}
+
+// confirm asks whether to go ahead with the plan summary describes,
+// returning an AbortedError if the answer is no.
+func (s *S3svc) confirm(summary PlanSummary) error {
+	if s.Confirm == nil {
+		return nil
+	}
+	summary.Protected = s.Protected
+	ok, err := s.Confirm(summary)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &AbortedError{Reason: "not confirmed"}
+	}
+	return nil
+}
+
+func planBytes(plan []*s3.ObjectVersion) int64 {
+	var bytes int64
+	for _, version := range plan {
+		bytes += aws.Int64Value(version.Size)
+	}
+	return bytes
+}
+
+// protected reports whether key is under one of the Protected prefixes,
+// which may use the wildcards path.Match does. Wildcards don't match "/".
+func (s *S3svc) protected(key string) bool {
+	for _, pattern := range s.Protected {
+		if strings.HasPrefix(key, pattern) {
+			return true
+		}
+		if !strings.ContainsAny(pattern, `*?[\`) {
+			continue
+		}
+		for i := len(key); i >= 0; i-- {
+			if ok, _ := path.Match(pattern, key[:i]); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseSize parses a number of bytes, optionally with a K, M, G or T suffix
+// for binary multiples, e.g. 10G. An optional trailing "iB" or "B" is
+// ignored.
+func parseSize(size string) (int64, error) {
+	s := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B"), "I")
+	multiplier := int64(1)
+	if i := strings.IndexAny(s, "KMGT"); i >= 0 && i == len(s)-1 {
+		multiplier = 1 << (10 * uint(strings.IndexByte("KMGT", s[i])+1))
+		s = s[:i]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size", size)
+	}
+	return n * multiplier, nil
+}
+
+// parseProtected returns the -protect-prefix patterns, checking they're
+// valid.
+func parseProtected(list string) ([]string, error) {
+	patterns := splitList(list)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("-protect-prefix %q: %s", pattern, err)
+		}
+	}
+	return patterns, nil
+}
+
+// addProtectFlags adds -protect-prefix to command, for commands which plan
+// restores. The returned function copies its value into the parsed
+// arguments.
+func addProtectFlags(command *flag.FlagSet) func(args map[string]string) map[string]string {
+	protect := command.String("protect-prefix", "", "Comma-separated prefixes of keys never to restore, which may use * and ? wildcards. Default none.")
+
+	return func(args map[string]string) map[string]string {
+		args["protect-prefix"] = *protect
+		return args
+	}
+}
+
+// addGuardFlags adds the options limiting what a restore may change to
+// command. The returned function copies their values into the parsed
+// arguments.
+func addGuardFlags(command *flag.FlagSet) func(args map[string]string) map[string]string {
+	protectArgs := addProtectFlags(command)
+	yes := command.Bool("yes", false, "Restore without showing the plan and asking for confirmation first. Required when not run interactively.")
+	maxChanges := command.Int("max-changes", 0, "Abort before copying anything if more than this many objects would be restored. 0 for no limit.")
+	maxBytes := command.String("max-bytes", "", "Abort before copying anything if more than this many bytes would be copied, e.g. 10G. Default no limit.")
+
+	return func(args map[string]string) map[string]string {
+		args["yes"] = strconv.FormatBool(*yes)
+		args["max-changes"] = strconv.Itoa(*maxChanges)
+		args["max-bytes"] = *maxBytes
+		return protectArgs(args)
+	}
+}
+
+// protectedVersion reports whether version is of a protected key, logging
+// that it's left alone if so.
+func (s *S3svc) protectedVersion(bucket string, version *s3.ObjectVersion) bool {
+	return s.protectedKey(bucket, *version.Key, *version.VersionId)
+}
+
+// protectedKey reports whether key is protected, logging that versionId isn't
+// restored over it if so.
+func (s *S3svc) protectedKey(bucket, key, versionId string) bool {
+	if !s.protected(key) {
+		return false
+	}
+	s.Log.Info("leaving protected key alone", "action", "protect", "bucket", bucket, "key", key, "version_id", versionId)
+	return true
+}
+
+// restoreGuarded restores prefix like RestorePrefix, but plans it first,
+// stopping as soon as the plan is larger than the limits allow, and asks for
+// confirmation before copying anything. The plan is only counted, and the
+// versions listed again to copy them, so this too runs in constant memory.
+func (s *S3svc) restoreGuarded(ctx context.Context, bucket, prefix string, restoreTime time.Time) error {
+
+	var objects int
+	var bytes int64
+	count := func(version *s3.ObjectVersion) error {
+		objects++
+		bytes += aws.Int64Value(version.Size)
+		return s.checkLimits(objects, bytes)
+	}
+	err := s.WalkPlan(ctx, bucket, prefix, restoreTime, func(version *s3.ObjectVersion, replaced string) error {
+		return count(version)
+	})
+	if err != nil {
+		return err
+	}
+	summary := PlanSummary{Bucket: bucket, Prefix: prefix, Timestamp: restoreTime, Objects: objects, Bytes: bytes}
+	if err := s.confirm(summary); err != nil {
+		return err
+	}
+
+	// The bucket may have changed since it was planned, so the limits are
+	// checked again as the versions are copied.
+	objects, bytes = 0, 0
+	mismatches := s.mismatchCount()
+	pool := s.startCopies(ctx, bucket)
+	err = s.WalkPlan(ctx, bucket, prefix, restoreTime, func(version *s3.ObjectVersion, replaced string) error {
+		if err := count(version); err != nil {
+			return err
+		}
+		return pool.Submit(version, replaced)
+	})
+	if waitErr := pool.Wait(); err == nil {
+		err = waitErr
+	}
+	if err == nil {
+		err = s.verificationError(mismatches)
+	}
+	return err
+}
@@ -0,0 +1,178 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Guardrails", func() {
+	var (
+		fake *fakeS3
+		svc  *S3svc
+	)
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("logs/2020/audit/a", "a2", 200, true),
+				version("logs/2020/audit/a", "a1", 100, false),
+				version("logs/2020/b", "b2", 200, true),
+				version("logs/2020/b", "b1", 100, false),
+				version("secrets/c", "c2", 200, true),
+				version("secrets/c", "c1", 100, false),
+			},
+		}
+		svc = fake.S3svc()
+	})
+
+	restore := func() error {
+		return svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))
+	}
+
+	It("Never restores protected keys", func() {
+		svc.Protected = []string{"secrets/", "logs/*/audit/"}
+		Expect(restore()).To(Succeed())
+		Expect(fake.Copied).To(Equal([]string{"b1"}))
+
+		fake.Copied = nil
+		Expect(svc.RestoreObjects(context.Background(), "mybucket", fake.Listing, time.Unix(150, 0))).To(Succeed())
+		Expect(fake.Copied).To(Equal([]string{"b1"}))
+	})
+
+	It("Aborts before copying anything when the plan has too many objects", func() {
+		svc.MaxChanges = 2
+		err := restore()
+		Expect(err).To(MatchError("aborted: more than 2 objects would be restored (-max-changes)"))
+		Expect(ExitCode(err)).To(Equal(ExitAborted))
+		Expect(fake.Calls["CopyObject"]).To(Equal(0))
+
+		svc.MaxChanges = 3
+		Expect(restore()).To(Succeed())
+		Expect(fake.Copied).To(HaveLen(3))
+	})
+
+	It("Aborts before copying anything when the plan has too many bytes", func() {
+		svc.MaxBytes = 25
+		Expect(restore()).To(MatchError("aborted: more than 25 B would be copied (-max-bytes)"))
+		Expect(fake.Calls["CopyObject"]).To(Equal(0))
+
+		Expect(svc.RestoreObjects(context.Background(), "mybucket", fake.Listing, time.Unix(150, 0))).To(BeAssignableToTypeOf(&AbortedError{}))
+		Expect(fake.Calls["CopyObject"]).To(Equal(0))
+	})
+
+	It("Asks for confirmation with a summary of the plan", func() {
+		var summaries []PlanSummary
+		answer := false
+		svc.Protected = []string{"secrets/"}
+		svc.Confirm = func(summary PlanSummary) (bool, error) {
+			summaries = append(summaries, summary)
+			return answer, nil
+		}
+
+		Expect(restore()).To(MatchError("aborted: not confirmed"))
+		Expect(fake.Calls["CopyObject"]).To(Equal(0))
+		Expect(summaries).To(HaveLen(1))
+		Expect(summaries[0].Objects).To(Equal(2))
+		Expect(summaries[0].Bytes).To(BeEquivalentTo(20))
+		Expect(summaries[0].Text()).To(Equal("Restore s3://mybucket/ to 1970-01-01T00:02:30Z: 2 objects, 20 B to copy, leaving keys under secrets/ alone"))
+
+		answer = true
+		Expect(restore()).To(Succeed())
+		Expect(fake.Copied).To(ConsistOf("a1", "b1"))
+		Expect(svc.Restored[0].ReplacedVersionId).To(Equal("a2"))
+	})
+
+	It("Lists the bucket again to copy what was confirmed", func() {
+		svc.Confirm = func(summary PlanSummary) (bool, error) {
+			fake.Calls["ListObjectVersions"] = 0
+			return true, nil
+		}
+
+		Expect(restore()).To(Succeed())
+		Expect(fake.Calls["ListObjectVersions"]).To(Equal(1))
+		Expect(fake.Copied).To(ConsistOf("a1", "b1", "c1"))
+	})
+
+	It("Stops copying if the bucket grew past the limits once confirmed", func() {
+		svc.MaxChanges = 3
+		svc.Confirm = func(summary PlanSummary) (bool, error) {
+			fake.Listing.Versions = append(fake.Listing.Versions,
+				version("secrets/d", "d2", 200, true),
+				version("secrets/d", "d1", 100, false),
+			)
+			return true, nil
+		}
+
+		Expect(restore()).To(MatchError("aborted: more than 3 objects would be restored (-max-changes)"))
+		Expect(fake.Copied).To(HaveLen(3))
+	})
+
+	It("Guards rollbacks the same way", func() {
+		var summaries []PlanSummary
+		svc.Protected = []string{"secrets/"}
+		svc.Confirm = func(summary PlanSummary) (bool, error) {
+			summaries = append(summaries, summary)
+			return true, nil
+		}
+		fake.Listing.Versions = append(fake.Listing.Versions, version("logs/new", "n1", 200, true))
+		rollback := func() error {
+			_, err := svc.RollbackObjects(context.Background(), "mybucket", fake.Listing, time.Unix(150, 0), time.Unix(250, 0))
+			return err
+		}
+
+		svc.MaxChanges = 2
+		Expect(rollback()).To(MatchError("aborted: more than 2 objects would be restored (-max-changes)"))
+		Expect(fake.Calls["CopyObject"] + fake.Calls["DeleteObject"]).To(Equal(0))
+
+		svc.MaxChanges = 0
+		Expect(rollback()).To(Succeed())
+		Expect(summaries[0].Text()).To(Equal("Roll back s3://mybucket/ from 1970-01-01T00:02:30Z to 1970-01-01T00:04:10Z: 3 objects, 20 B to copy, 1 to delete, leaving keys under secrets/ alone"))
+		Expect(fake.Copied).To(ConsistOf("a1", "b1"))
+		Expect(fake.Deleted).To(Equal([]string{"logs/new"}))
+	})
+
+	It("Guards restoring exact versions the same way", func() {
+		fake.Heads["a1"] = http.Header{"Content-Length": []string{"10"}}
+		fake.Heads["c1"] = http.Header{"Content-Length": []string{"10"}}
+		svc.Protected = []string{"secrets/"}
+		restores := []VersionRestore{{Key: "logs/2020/audit/a", VersionId: "a1"}, {Key: "secrets/c", VersionId: "c1"}}
+
+		svc.MaxBytes = 5
+		_, err := svc.RestoreVersions(context.Background(), "mybucket", restores)
+		Expect(err).To(MatchError("aborted: more than 5 B would be copied (-max-bytes)"))
+		Expect(fake.Calls["CopyObject"]).To(Equal(0))
+
+		svc.MaxBytes = 0
+		var summary PlanSummary
+		svc.Confirm = func(s PlanSummary) (bool, error) {
+			summary = s
+			return true, nil
+		}
+		restored, err := svc.RestoreVersions(context.Background(), "mybucket", restores)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(restored).To(HaveLen(1))
+		Expect(fake.Copied).To(Equal([]string{"a1"}))
+		Expect(summary.Text()).To(Equal("Restore versions in s3://mybucket/: 1 objects, 10 B to copy, leaving keys under secrets/ alone"))
+	})
+
+	It("Prompts on a terminal", func() {
+		var out bytes.Buffer
+		prompt := Prompt(strings.NewReader("Y\nno\n"), &out)
+		summary := PlanSummary{Bucket: "mybucket", Timestamp: time.Unix(150, 0), Objects: 1, Bytes: 2048}
+
+		Expect(prompt(summary)).To(BeTrue())
+		Expect(out.String()).To(Equal("Restore s3://mybucket/ to 1970-01-01T00:02:30Z: 1 objects, 2.0 KiB to copy\nContinue? [y/N] "))
+		Expect(prompt(summary)).To(BeFalse())
+		Expect(prompt(summary)).To(BeFalse())
+	})
+})
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// HistoryEntry is a version or delete marker of a key. Writer comes from the
+// version's user metadata if present, otherwise from its owner.
+type HistoryEntry struct {
+	VersionId    string
+	LastModified time.Time
+	IsLatest     bool
+	DeleteMarker bool
+	Size         int64
+	ETag         string
+	StorageClass string
+	Writer       string
+	Selected     bool
+}
+
+// KeyHistory returns every version and delete marker of key, most recent
+// first. The version RestoreObjects would pick for restoreTime is marked as
+// selected, unless restoreTime is zero. If writerKey is set, versions are
+// looked up to find who wrote them in that user metadata key. Other keys in
+// the listing, e.g. ones key is a prefix of, are ignored.
+func (s *S3svc) KeyHistory(ctx context.Context, bucket, key string, versions *s3.ListObjectVersionsOutput, restoreTime time.Time, writerKey string) ([]HistoryEntry, error) {
+
+	var keyVersions []*s3.ObjectVersion
+	for _, version := range versions.Versions {
+		if *version.Key == key {
+			keyVersions = append(keyVersions, version)
+		}
+	}
+	var keyMarkers []*s3.DeleteMarkerEntry
+	for _, marker := range versions.DeleteMarkers {
+		if *marker.Key == key {
+			keyMarkers = append(keyMarkers, marker)
+		}
+	}
+
+	var entries []HistoryEntry
+	for _, version := range keyVersions {
+		entry := HistoryEntry{
+			VersionId:    *version.VersionId,
+			LastModified: *version.LastModified,
+			IsLatest:     *version.IsLatest,
+		}
+		if version.Size != nil {
+			entry.Size = *version.Size
+		}
+		if version.ETag != nil {
+			entry.ETag = *version.ETag
+		}
+		if version.StorageClass != nil {
+			entry.StorageClass = *version.StorageClass
+		}
+		if version.Owner != nil && version.Owner.DisplayName != nil {
+			entry.Writer = *version.Owner.DisplayName
+		}
+		if writerKey != "" {
+			head, err := s.HeadVersion(ctx, bucket, key, *version.VersionId)
+			if err != nil {
+				return nil, err
+			}
+			for metadataKey, value := range head.Metadata {
+				if strings.EqualFold(metadataKey, writerKey) && value != nil {
+					entry.Writer = *value
+				}
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	for _, marker := range keyMarkers {
+		entry := HistoryEntry{
+			VersionId:    *marker.VersionId,
+			LastModified: *marker.LastModified,
+			IsLatest:     *marker.IsLatest,
+			DeleteMarker: true,
+		}
+		if marker.Owner != nil && marker.Owner.DisplayName != nil {
+			entry.Writer = *marker.Owner.DisplayName
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].LastModified.After(entries[j].LastModified)
+	})
+
+	if !restoreTime.IsZero() {
+		selected, err := s.selectVersion(ctx, bucket, keyVersions, keyMarkers, restoreTime)
+		if err != nil {
+			return nil, err
+		}
+		for i := range entries {
+			if selected != nil && entries[i].VersionId == *selected.VersionId && !entries[i].DeleteMarker {
+				entries[i].Selected = true
+			}
+		}
+	}
+	return entries, nil
+}
+
+// WriteHistory writes entries in the style of git log.
+func WriteHistory(w io.Writer, entries []HistoryEntry) {
+	for i, entry := range entries {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+
+		kind := "version"
+		if entry.DeleteMarker {
+			kind = "delete marker"
+		}
+		var labels []string
+		if entry.IsLatest {
+			labels = append(labels, "latest")
+		}
+		if entry.Selected {
+			labels = append(labels, "selected")
+		}
+		if len(labels) > 0 {
+			fmt.Fprintf(w, "%s %s (%s)\n", kind, entry.VersionId, strings.Join(labels, ", "))
+		} else {
+			fmt.Fprintf(w, "%s %s\n", kind, entry.VersionId)
+		}
+
+		fmt.Fprintf(w, "Date:    %s\n", entry.LastModified.UTC().Format(time.RFC1123Z))
+		if entry.Writer != "" {
+			fmt.Fprintf(w, "Writer:  %s\n", entry.Writer)
+		}
+		if !entry.DeleteMarker {
+			fmt.Fprintf(w, "Size:    %d\n", entry.Size)
+			fmt.Fprintf(w, "ETag:    %s\n", entry.ETag)
+			fmt.Fprintf(w, "Storage: %s\n", entry.StorageClass)
+		}
+	}
+}
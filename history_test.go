@@ -0,0 +1,77 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("History", func() {
+	var (
+		fake     *fakeS3
+		versions *s3.ListObjectVersionsOutput
+	)
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+		versions = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("a", "v2", 300, false),
+				version("a", "v1", 100, false),
+				version("ab", "other", 200, true),
+			},
+			DeleteMarkers: []*s3.DeleteMarkerEntry{deleteMarker("a", "d1", 400, true)},
+		}
+		versions.Versions[0].StorageClass = aws.String("STANDARD")
+		versions.Versions[0].Owner = &s3.Owner{DisplayName: aws.String("paas")}
+	})
+
+	It("Lists versions and delete markers of the key, newest first", func() {
+		entries, err := fake.S3svc().KeyHistory(context.Background(), "mybucket", "a", versions, time.Time{}, "")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(HaveLen(3))
+		Expect(entries[0]).To(Equal(HistoryEntry{VersionId: "d1", LastModified: time.Unix(400, 0), IsLatest: true, DeleteMarker: true}))
+		Expect(entries[1].VersionId).To(Equal("v2"))
+		Expect(entries[1].Writer).To(Equal("paas"))
+		Expect(entries[2].VersionId).To(Equal("v1"))
+		Expect(fake.Calls).To(BeEmpty())
+	})
+
+	It("Marks the selected version and finds writers in metadata", func() {
+		fake.Heads["v1"] = http.Header{"X-Amz-Meta-Writer": []string{"deploy-41"}}
+		fake.Heads["v2"] = http.Header{}
+
+		entries, err := fake.S3svc().KeyHistory(context.Background(), "mybucket", "a", versions, time.Unix(350, 0), "writer")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries[1].Selected).To(BeTrue())
+		Expect(entries[1].Writer).To(Equal("paas"))
+		Expect(entries[2].Writer).To(Equal("deploy-41"))
+
+		var out bytes.Buffer
+		WriteHistory(&out, entries)
+		Expect(out.String()).To(HavePrefix("delete marker d1 (latest)\nDate:    Thu, 01 Jan 1970 00:06:40 +0000\n\nversion v2 (selected)\n"))
+	})
+
+	It("Only looks up the key's own versions to select one", func() {
+		fake.Heads["v2"] = http.Header{"Content-Type": []string{"text/plain"}}
+		svc := fake.S3svc()
+		svc.Filters = []VersionFilter{ContentTypeFilter{ContentTypes: []string{"image/png"}}}
+
+		entries, err := svc.KeyHistory(context.Background(), "mybucket", "a", versions, time.Unix(350, 0), "")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries[1].Selected).To(BeTrue())
+		Expect(fake.Calls["HeadObject"]).To(Equal(1))
+	})
+
+})
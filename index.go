@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// indexedKey holds a key's versions and delete markers, most recent first.
+type indexedKey struct {
+	Versions      []*s3.ObjectVersion
+	DeleteMarkers []*s3.DeleteMarkerEntry
+}
+
+// latest returns the current version of the key, or nil if it's deleted.
+func (k *indexedKey) latest() *s3.ObjectVersion {
+	for _, version := range k.Versions {
+		if *version.IsLatest {
+			return version
+		}
+	}
+	return nil
+}
+
+// VersionIndex is a local copy of a bucket's version listing. Prefixes are the
+// prefixes which have been listed in full. Keys under them are kept up to date
+// by RefreshIndex, which only lists the versions of keys whose current object has
+// changed. The index is a single file read into memory in full, so
+// S3svc.MaxIndexVersions bounds how big it may grow.
+type VersionIndex struct {
+	Bucket   string
+	Prefixes []string
+	Updated  time.Time
+	Keys     map[string]*indexedKey
+
+	path string
+}
+
+// addListingFlags adds the options controlling how versions are listed to
+// command. The returned function copies their values into the parsed
+// arguments.
+func addListingFlags(command *flag.FlagSet) func(args map[string]string) map[string]string {
+	home, _ := os.UserHomeDir()
+	useIndex := command.Bool("use-index", false, "Query a local index of the bucket's versions, refreshing it first.")
+	indexDir := command.String("index-dir", filepath.Join(home, ".s3r", "index"), "Directory to keep local indexes in.")
+	listParallelism := command.Int("list-parallelism", 1, "Number of prefixes, split at \"/\", to list versions of concurrently. Prefixes with more than a page of versions are split again. Flat buckets, without \"/\" in their keys, gain nothing.")
+	indexMaxVersions := command.Int("index-max-versions", DefaultMaxIndexVersions, "Refuse to use a local index or inventory report of more than this many versions and delete markers, which are all held in memory. 0 for no limit.")
+
+	return func(args map[string]string) map[string]string {
+		args["use-index"] = strconv.FormatBool(*useIndex)
+		args["index-dir"] = *indexDir
+		args["list-parallelism"] = strconv.Itoa(*listParallelism)
+		args["index-max-versions"] = strconv.Itoa(*indexMaxVersions)
+		return args
+	}
+}
+
+// DefaultMaxIndexVersions is about as many versions as fit in a few GB of
+// memory.
+const DefaultMaxIndexVersions = 5000000
+
+// Size returns how many versions and delete markers are indexed.
+func (idx *VersionIndex) Size() int {
+	size := 0
+	for _, k := range idx.Keys {
+		size += len(k.Versions) + len(k.DeleteMarkers)
+	}
+	return size
+}
+
+// checkIndexSize returns a UsageError if an index or inventory report of size
+// versions is more than MaxIndexVersions.
+func (s *S3svc) checkIndexSize(bucket string, size int) error {
+	if s.MaxIndexVersions > 0 && size > s.MaxIndexVersions {
+		return &UsageError{Message: fmt.Sprintf("the index of %s has more than %d versions (-index-max-versions); list without -use-index or -inventory, or raise the limit", bucket, s.MaxIndexVersions)}
+	}
+	return nil
+}
+
+// IndexPath returns where the index of bucket is kept within dir.
+func IndexPath(dir, bucket string) string {
+	return filepath.Join(dir, url.PathEscape(bucket)+".index")
+}
+
+// LoadIndex reads an index saved by Save. A missing index is empty.
+func LoadIndex(path, bucket string) (*VersionIndex, error) {
+
+	idx := &VersionIndex{Bucket: bucket, Keys: make(map[string]*indexedKey), path: path}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := gob.NewDecoder(f).Decode(idx); err != nil {
+		return nil, fmt.Errorf("reading index %s: %s", path, err)
+	}
+	if idx.Bucket != bucket {
+		return nil, fmt.Errorf("index %s is of bucket %s, not %s", path, idx.Bucket, bucket)
+	}
+	return idx, nil
+}
+
+// Save writes the index atomically, so an interrupted refresh leaves the
+// previous one intact.
+func (idx *VersionIndex) Save() error {
+
+	if err := os.MkdirAll(filepath.Dir(idx.path), 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(idx.path), ".index")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := gob.NewEncoder(f).Encode(idx); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), idx.path)
+}
+
+func (idx *VersionIndex) covers(prefix string) bool {
+	for _, covered := range idx.Prefixes {
+		if strings.HasPrefix(prefix, covered) {
+			return true
+		}
+	}
+	return false
+}
+
+// replace sets the versions of every key for which match is true to those
+// found in versions.
+func (idx *VersionIndex) replace(versions *s3.ListObjectVersionsOutput, match func(key string) bool) {
+	for key := range idx.Keys {
+		if match(key) {
+			delete(idx.Keys, key)
+		}
+	}
+
+	get := func(key string) *indexedKey {
+		k, ok := idx.Keys[key]
+		if !ok {
+			k = &indexedKey{}
+			idx.Keys[key] = k
+		}
+		return k
+	}
+	for _, version := range versions.Versions {
+		if match(*version.Key) {
+			k := get(*version.Key)
+			k.Versions = append(k.Versions, version)
+		}
+	}
+	for _, marker := range versions.DeleteMarkers {
+		if match(*marker.Key) {
+			k := get(*marker.Key)
+			k.DeleteMarkers = append(k.DeleteMarkers, marker)
+		}
+	}
+}
+
+// Listing returns the indexed versions under prefix in the order
+// ListObjectVersions returns them.
+func (idx *VersionIndex) Listing(prefix string) *s3.ListObjectVersionsOutput {
+
+	var keys []string
+	for key := range idx.Keys {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	listing := &s3.ListObjectVersionsOutput{
+		Name:   aws.String(idx.Bucket),
+		Prefix: aws.String(prefix),
+	}
+	for _, key := range keys {
+		listing.Versions = append(listing.Versions, idx.Keys[key].Versions...)
+		listing.DeleteMarkers = append(listing.DeleteMarkers, idx.Keys[key].DeleteMarkers...)
+	}
+	return listing
+}
+
+// RefreshIndex brings the keys under prefix up to date. Prefixes which haven't
+// been indexed are listed in full. Otherwise the current objects are listed
+// and only keys whose latest version changed have their versions listed
+// again. Versions removed without changing the current object, e.g. by a
+// lifecycle policy, are only noticed when the index is rebuilt.
+func (s *S3svc) RefreshIndex(ctx context.Context, idx *VersionIndex, prefix string) error {
+
+	if !idx.covers(prefix) {
+		versions, err := s.listAllVersions(ctx, idx.Bucket, prefix)
+		if err != nil {
+			return err
+		}
+		idx.replace(versions, func(key string) bool {
+			return strings.HasPrefix(key, prefix)
+		})
+
+		prefixes := []string{prefix}
+		for _, covered := range idx.Prefixes {
+			if !strings.HasPrefix(covered, prefix) {
+				prefixes = append(prefixes, covered)
+			}
+		}
+		idx.Prefixes = prefixes
+		idx.Updated = time.Now()
+		return nil
+	}
+
+	current := make(map[string]*s3.Object)
+	listParams := &s3.ListObjectsV2Input{
+		Bucket: aws.String(idx.Bucket),
+		Prefix: aws.String(prefix),
+	}
+	req, _ := s.Svc.ListObjectsV2Request(listParams)
+	err := sendPages(ctx, req, func(data interface{}) bool {
+		page := data.(*s3.ListObjectsV2Output)
+		for _, object := range page.Contents {
+			current[*object.Key] = object
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	changed := make(map[string]bool)
+	for key, object := range current {
+		k, ok := idx.Keys[key]
+		if !ok {
+			changed[key] = true
+			continue
+		}
+		latest := k.latest()
+		if latest == nil || !latest.LastModified.Equal(*object.LastModified) || aws.StringValue(latest.ETag) != aws.StringValue(object.ETag) {
+			changed[key] = true
+		}
+	}
+	for key, k := range idx.Keys {
+		if _, ok := current[key]; !ok && strings.HasPrefix(key, prefix) && k.latest() != nil {
+			changed[key] = true
+		}
+	}
+
+	for key := range changed {
+		versions, err := s.listKeyVersions(ctx, idx.Bucket, key)
+		if err != nil {
+			return err
+		}
+		idx.replace(versions, func(other string) bool {
+			return other == key
+		})
+	}
+	idx.Updated = time.Now()
+	return nil
+}
+
+// listKeyVersions lists the versions and delete markers of key alone. Keys
+// it's a prefix of come after it, so listing stops at the first page with
+// one, rather than paging through all of them.
+func (s *S3svc) listKeyVersions(ctx context.Context, bucket, key string) (*s3.ListObjectVersionsOutput, error) {
+
+	listing := &s3.ListObjectVersionsOutput{
+		Name:   aws.String(bucket),
+		Prefix: aws.String(key),
+	}
+	req, _ := s.Svc.ListObjectVersionsRequest(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(key),
+	})
+	err := sendPages(ctx, req, func(data interface{}) bool {
+		page := data.(*s3.ListObjectVersionsOutput)
+		more := true
+		for _, version := range page.Versions {
+			if *version.Key == key {
+				listing.Versions = append(listing.Versions, version)
+			} else {
+				more = false
+			}
+		}
+		for _, marker := range page.DeleteMarkers {
+			if *marker.Key == key {
+				listing.DeleteMarkers = append(listing.DeleteMarkers, marker)
+			} else {
+				more = false
+			}
+		}
+		return more
+	})
+	if err != nil {
+		return nil, err
+	}
+	return listing, nil
+}
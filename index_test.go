@@ -0,0 +1,142 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Version index", func() {
+	var (
+		fake *fakeS3
+		svc  *S3svc
+		dir  string
+	)
+
+	keys := func(versions *s3.ListObjectVersionsOutput) []string {
+		var ids []string
+		for _, v := range versions.Versions {
+			ids = append(ids, *v.VersionId)
+		}
+		for _, m := range versions.DeleteMarkers {
+			ids = append(ids, *m.VersionId)
+		}
+		return ids
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "s3r-index")
+		Expect(err).ToNot(HaveOccurred())
+
+		fake = newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("a", "a2", 200, true),
+				version("a", "a1", 100, false),
+				version("b", "b1", 100, true),
+				version("c", "c1", 100, true),
+			},
+		}
+		svc = fake.S3svc()
+		svc.IndexDir = dir
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("Builds the index from a full listing", func() {
+		versions, err := svc.ListVersions(context.Background(), "mybucket", "")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys(versions)).To(Equal([]string{"a2", "a1", "b1", "c1"}))
+		Expect(fake.Calls["ListObjectVersions"]).To(Equal(1))
+		Expect(IndexPath(dir, "mybucket")).To(BeAnExistingFile())
+	})
+
+	It("Only lists versions of changed keys on refresh", func() {
+		_, err := svc.ListVersions(context.Background(), "mybucket", "")
+		Expect(err).ToNot(HaveOccurred())
+
+		fake.Listing.Versions[2].IsLatest = aws.Bool(false)
+		fake.Listing.Versions = append(fake.Listing.Versions, version("b", "b2", 300, true), version("d", "d1", 300, true))
+		fake.Listing.Versions[3].IsLatest = aws.Bool(false)
+		fake.Listing.DeleteMarkers = []*s3.DeleteMarkerEntry{deleteMarker("c", "cd", 300, true)}
+
+		svc = fake.S3svc()
+		svc.IndexDir = dir
+		versions, err := svc.ListVersions(context.Background(), "mybucket", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys(versions)).To(ConsistOf("a2", "a1", "b2", "b1", "c1", "cd", "d1"))
+		Expect(fake.Calls["ListObjectsV2"]).To(Equal(1))
+		// b, c and d changed, so one full listing and three per-key ones.
+		Expect(fake.Calls["ListObjectVersions"]).To(Equal(4))
+	})
+
+	It("Stops listing a changed key at the keys it's a prefix of", func() {
+		fake.Listing.Versions = append(fake.Listing.Versions, version("d", "d1", 100, true))
+		for i := 0; i < 10; i++ {
+			fake.Listing.Versions = append(fake.Listing.Versions, version(fmt.Sprintf("d/%d", i), fmt.Sprintf("d%d", i), 100, true))
+		}
+		fake.PageSize = 3
+		_, err := svc.ListVersions(context.Background(), "mybucket", "")
+		Expect(err).ToNot(HaveOccurred())
+
+		fake.Listing.Versions[4].IsLatest = aws.Bool(false)
+		fake.Listing.Versions = append(fake.Listing.Versions, version("d", "d2", 300, true))
+		fake.Calls = map[string]int{}
+		versions, err := svc.ListVersions(context.Background(), "mybucket", "")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys(versions)).To(ContainElement("d2"))
+		Expect(keys(versions)).To(HaveLen(16))
+		Expect(fake.Calls["ListObjectVersions"]).To(Equal(1))
+	})
+
+	It("Refuses to keep an index of more than MaxIndexVersions", func() {
+		svc.MaxIndexVersions = 3
+		_, err := svc.ListVersions(context.Background(), "mybucket", "")
+
+		Expect(err).To(BeAssignableToTypeOf(&UsageError{}))
+		Expect(IndexPath(dir, "mybucket")).ToNot(BeAnExistingFile())
+
+		svc.MaxIndexVersions = 4
+		_, err = svc.ListVersions(context.Background(), "mybucket", "")
+		Expect(err).ToNot(HaveOccurred())
+
+		svc.MaxIndexVersions = 3
+		_, err = svc.ListVersions(context.Background(), "mybucket", "")
+		Expect(err).To(MatchError(ContainSubstring("more than 3 versions (-index-max-versions)")))
+	})
+
+	It("Answers narrower prefixes from the index", func() {
+		_, err := svc.ListVersions(context.Background(), "mybucket", "")
+		Expect(err).ToNot(HaveOccurred())
+
+		versions, err := svc.ListVersions(context.Background(), "mybucket", "a")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys(versions)).To(Equal([]string{"a2", "a1"}))
+		Expect(fake.Calls["ListObjectVersions"]).To(Equal(1))
+	})
+
+	It("Lists versions since a point in time", func() {
+		versions, err := svc.ListVersions(context.Background(), "mybucket", "")
+		Expect(err).ToNot(HaveOccurred())
+
+		var out bytes.Buffer
+		WriteVersions(&out, versions, time.Unix(150, 0))
+		Expect(out.String()).To(Equal("1970-01-01T00:03:20Z  a2                                          10  a (latest)\n"))
+	})
+
+})
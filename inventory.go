@@ -0,0 +1,251 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// inventoryManifest is the manifest.json written with each S3 Inventory
+// report.
+type inventoryManifest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	DestinationBucket string `json:"destinationBucket"`
+	CreationTimestamp string `json:"creationTimestamp"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	Files             []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+}
+
+// splitS3URL splits s3://bucket/key into its bucket and key.
+func splitS3URL(location string) (bucket, key string, ok bool) {
+	if !strings.HasPrefix(location, "s3://") {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(location, "s3://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+func (s *S3svc) openS3Object(bucket, key string) (io.ReadCloser, error) {
+
+	getResp, err := s.Svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading s3://%s/%s: %s", bucket, key, err)
+	}
+	return getResp.Body, nil
+}
+
+// openLocalInventoryFile opens a data file of a report copied to dir. Data
+// file keys are relative to the destination bucket, so a copy of it is looked
+// for in each directory above the manifest, then next to the manifest.
+func openLocalInventoryFile(dir, key string) (io.ReadCloser, error) {
+	for d := dir; ; d = filepath.Dir(d) {
+		f, err := os.Open(filepath.Join(d, filepath.FromSlash(key)))
+		if err == nil {
+			return f, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	return os.Open(filepath.Join(dir, filepath.Base(key)))
+}
+
+// LoadInventory reads the S3 Inventory report of bucket whose manifest is at
+// location, either s3://bucket/key or a local path. Only the versions under
+// prefix are kept. The report must be in CSV format and include all versions.
+// The returned index is as of when the report was created, and covers the
+// whole bucket.
+func (s *S3svc) LoadInventory(location, bucket, prefix string) (*VersionIndex, error) {
+
+	manifestBucket, manifestKey, inS3 := splitS3URL(location)
+	var manifestFile io.ReadCloser
+	var err error
+	if inS3 {
+		manifestFile, err = s.openS3Object(manifestBucket, manifestKey)
+	} else {
+		manifestFile, err = os.Open(location)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer manifestFile.Close()
+
+	var manifest inventoryManifest
+	if err := json.NewDecoder(manifestFile).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("reading inventory manifest %s: %s", location, err)
+	}
+	if manifest.SourceBucket != bucket {
+		return nil, fmt.Errorf("inventory %s is of bucket %s, not %s", location, manifest.SourceBucket, bucket)
+	}
+	if !strings.EqualFold(manifest.FileFormat, "CSV") {
+		return nil, fmt.Errorf("%s inventory reports aren't supported, only CSV", manifest.FileFormat)
+	}
+	created, err := strconv.ParseInt(manifest.CreationTimestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("inventory %s has invalid creation timestamp %q", location, manifest.CreationTimestamp)
+	}
+
+	// Data files are written to the destination bucket, which is usually
+	// where the manifest is too.
+	open := func(key string) (io.ReadCloser, error) {
+		return openLocalInventoryFile(filepath.Dir(location), key)
+	}
+	if inS3 {
+		dataBucket := strings.TrimPrefix(manifest.DestinationBucket, "arn:aws:s3:::")
+		if dataBucket == "" {
+			dataBucket = manifestBucket
+		}
+		open = func(key string) (io.ReadCloser, error) {
+			return s.openS3Object(dataBucket, key)
+		}
+	}
+
+	columns := make(map[string]int)
+	for i, name := range strings.Split(manifest.FileSchema, ",") {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range []string{"Key", "VersionId", "IsLatest", "IsDeleteMarker", "LastModifiedDate"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("inventory %s doesn't include %s, it must list all versions", location, name)
+		}
+	}
+
+	listing := &s3.ListObjectVersionsOutput{}
+	for _, file := range manifest.Files {
+		if err := readInventoryFile(open, file.Key, columns, prefix, listing); err != nil {
+			return nil, err
+		}
+		if err := s.checkIndexSize(bucket, len(listing.Versions)+len(listing.DeleteMarkers)); err != nil {
+			return nil, err
+		}
+	}
+
+	// Reports aren't in any particular order.
+	sort.SliceStable(listing.Versions, func(i, j int) bool {
+		a, b := listing.Versions[i], listing.Versions[j]
+		if *a.Key != *b.Key {
+			return *a.Key < *b.Key
+		}
+		return a.LastModified.After(*b.LastModified)
+	})
+	sort.SliceStable(listing.DeleteMarkers, func(i, j int) bool {
+		a, b := listing.DeleteMarkers[i], listing.DeleteMarkers[j]
+		if *a.Key != *b.Key {
+			return *a.Key < *b.Key
+		}
+		return a.LastModified.After(*b.LastModified)
+	})
+
+	idx := &VersionIndex{
+		Bucket:   bucket,
+		Prefixes: []string{""},
+		Updated:  time.Unix(0, created*int64(time.Millisecond)),
+		Keys:     make(map[string]*indexedKey),
+	}
+	idx.replace(listing, func(key string) bool {
+		return true
+	})
+	return idx, nil
+}
+
+func readInventoryFile(open func(key string) (io.ReadCloser, error), key string, columns map[string]int, prefix string, listing *s3.ListObjectVersionsOutput) error {
+
+	f, err := open(key)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("reading inventory file %s: %s", key, err)
+	}
+	r := csv.NewReader(gz)
+	r.FieldsPerRecord = -1
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading inventory file %s: %s", key, err)
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+
+		// Keys are URL encoded.
+		objectKey, err := url.QueryUnescape(field("Key"))
+		if err != nil {
+			return fmt.Errorf("reading inventory file %s: invalid key %q", key, field("Key"))
+		}
+		if !strings.HasPrefix(objectKey, prefix) {
+			continue
+		}
+		lastModified, err := time.Parse(time.RFC3339, field("LastModifiedDate"))
+		if err != nil {
+			return fmt.Errorf("reading inventory file %s: invalid date %q", key, field("LastModifiedDate"))
+		}
+		versionId := field("VersionId")
+		if versionId == "" {
+			versionId = "null"
+		}
+		isLatest := field("IsLatest") == "true"
+
+		if field("IsDeleteMarker") == "true" {
+			listing.DeleteMarkers = append(listing.DeleteMarkers, &s3.DeleteMarkerEntry{
+				Key:          aws.String(objectKey),
+				VersionId:    aws.String(versionId),
+				IsLatest:     aws.Bool(isLatest),
+				LastModified: aws.Time(lastModified),
+			})
+			continue
+		}
+
+		version := &s3.ObjectVersion{
+			Key:          aws.String(objectKey),
+			VersionId:    aws.String(versionId),
+			IsLatest:     aws.Bool(isLatest),
+			LastModified: aws.Time(lastModified),
+		}
+		if size, err := strconv.ParseInt(field("Size"), 10, 64); err == nil {
+			version.Size = aws.Int64(size)
+		}
+		// Listings quote ETags, reports don't.
+		if etag := field("ETag"); etag != "" {
+			version.ETag = aws.String(`"` + etag + `"`)
+		}
+		if storageClass := field("StorageClass"); storageClass != "" {
+			version.StorageClass = aws.String(storageClass)
+		}
+		listing.Versions = append(listing.Versions, version)
+	}
+}
@@ -0,0 +1,174 @@
+package main_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const inventoryManifest = `{
+  "sourceBucket": "mybucket",
+  "destinationBucket": "arn:aws:s3:::inventories",
+  "version": "2016-11-30",
+  "creationTimestamp": "300000",
+  "fileFormat": "%s",
+  "fileSchema": "Bucket, Key, VersionId, IsLatest, IsDeleteMarker, Size, LastModifiedDate",
+  "files": [
+    {"key": "reports/mybucket/all/data/one.csv.gz", "size": 1, "MD5checksum": "x"},
+    {"key": "reports/mybucket/all/data/two.csv.gz", "size": 1, "MD5checksum": "x"}
+  ]
+}`
+
+func gzipped(text string) string {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte(text))
+	w.Close()
+	return b.String()
+}
+
+var _ = Describe("Inventory reports", func() {
+	var (
+		fake  *fakeS3
+		svc   *S3svc
+		files map[string]string
+	)
+
+	ids := func(versions *s3.ListObjectVersionsOutput) []string {
+		var ids []string
+		for _, v := range versions.Versions {
+			ids = append(ids, *v.VersionId)
+		}
+		for _, m := range versions.DeleteMarkers {
+			ids = append(ids, *m.VersionId)
+		}
+		return ids
+	}
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("a", "a2", 200, true),
+				version("a", "a1", 100, false),
+				version("b c", "bc1", 100, false),
+				version("d", "d1", 100, true),
+			},
+			DeleteMarkers: []*s3.DeleteMarkerEntry{
+				deleteMarker("b c", "bc2", 200, true),
+			},
+		}
+		svc = fake.S3svc()
+
+		// The report lists versions in no particular order, across files.
+		files = map[string]string{
+			"reports/mybucket/all/2026-10-18T00-00Z/manifest.json": fmt.Sprintf(inventoryManifest, "CSV"),
+			"reports/mybucket/all/data/one.csv.gz": gzipped(
+				`"mybucket","a","a1","false","false","10","1970-01-01T00:01:40.000Z"` + "\n" +
+					`"mybucket","b+c","bc2","true","true","","1970-01-01T00:03:20.000Z"` + "\n"),
+			"reports/mybucket/all/data/two.csv.gz": gzipped(
+				`"mybucket","a","a2","true","false","10","1970-01-01T00:03:20.000Z"` + "\n" +
+					`"mybucket","d","d1","true","false","10","1970-01-01T00:01:40.000Z"` + "\n" +
+					`"mybucket","b+c","bc1","false","false","10","1970-01-01T00:01:40.000Z"` + "\n"),
+		}
+		for key, body := range files {
+			fake.Bodies["inventories/"+key] = body
+		}
+	})
+
+	Context("Kept locally", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "s3r-inventory")
+			Expect(err).ToNot(HaveOccurred())
+			for key, body := range files {
+				path := filepath.Join(dir, filepath.FromSlash(key))
+				Expect(os.MkdirAll(filepath.Dir(path), 0700)).To(Succeed())
+				Expect(ioutil.WriteFile(path, []byte(body), 0600)).To(Succeed())
+			}
+			svc.Inventory = filepath.Join(dir, "reports/mybucket/all/2026-10-18T00-00Z/manifest.json")
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("Reads versions from the report instead of listing them", func() {
+			versions, err := svc.ListVersions(context.Background(), "mybucket", "")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fake.Calls["ListObjectVersions"]).To(Equal(0))
+			Expect(fake.Calls["ListObjectsV2"]).To(Equal(1))
+			Expect(ids(versions)).To(Equal([]string{"a2", "a1", "bc1", "d1", "bc2"}))
+			Expect(*versions.Versions[2].Key).To(Equal("b c"))
+		})
+
+		It("Only keeps versions under the prefix", func() {
+			versions, err := svc.ListVersions(context.Background(), "mybucket", "a")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ids(versions)).To(Equal([]string{"a2", "a1"}))
+		})
+
+		It("Lists keys changed since the report", func() {
+			fake.Listing.Versions[0].IsLatest = aws.Bool(false)
+			fake.Listing.Versions = append(fake.Listing.Versions, version("a", "a3", 400, true))
+
+			versions, err := svc.ListVersions(context.Background(), "mybucket", "")
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fake.Calls["ListObjectVersions"]).To(Equal(1))
+			Expect(ids(versions)).To(Equal([]string{"a3", "a2", "a1", "bc1", "d1", "bc2"}))
+		})
+
+		It("Plans restores from the report", func() {
+			var planned []string
+			err := svc.WalkPlan(context.Background(), "mybucket", "", time.Unix(150, 0), func(version *s3.ObjectVersion, replaced string) error {
+				planned = append(planned, *version.VersionId)
+				return nil
+			})
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(planned).To(Equal([]string{"a1", "bc1"}))
+		})
+
+		It("Refuses reports of another bucket", func() {
+			_, err := svc.ListVersions(context.Background(), "otherbucket", "")
+
+			Expect(err).To(MatchError(ContainSubstring("is of bucket mybucket, not otherbucket")))
+		})
+	})
+
+	It("Reads reports from S3", func() {
+		svc.Inventory = "s3://inventories/reports/mybucket/all/2026-10-18T00-00Z/manifest.json"
+
+		versions, err := svc.ListVersions(context.Background(), "mybucket", "")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fake.Calls["GetObject"]).To(Equal(3))
+		Expect(ids(versions)).To(Equal([]string{"a2", "a1", "bc1", "d1", "bc2"}))
+	})
+
+	It("Refuses formats other than CSV", func() {
+		fake.Bodies["inventories/reports/mybucket/all/2026-10-18T00-00Z/manifest.json"] = fmt.Sprintf(inventoryManifest, "ORC")
+		svc.Inventory = "s3://inventories/reports/mybucket/all/2026-10-18T00-00Z/manifest.json"
+
+		_, err := svc.ListVersions(context.Background(), "mybucket", "")
+
+		Expect(err).To(MatchError("ORC inventory reports aren't supported, only CSV"))
+	})
+})
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// WriteVersions writes the versions and delete markers in the listing which
+// were created at or after since, grouped by key, most recent first.
+func WriteVersions(w io.Writer, versions *s3.ListObjectVersionsOutput, since time.Time) {
+
+	type line struct {
+		key          string
+		lastModified time.Time
+		text         string
+	}
+	var lines []line
+	for _, version := range versions.Versions {
+		if version.LastModified.Before(since) {
+			continue
+		}
+		latest := ""
+		if *version.IsLatest {
+			latest = " (latest)"
+		}
+		lines = append(lines, line{*version.Key, *version.LastModified, fmt.Sprintf("%s  %-32s  %12d  %s%s",
+			version.LastModified.UTC().Format(time.RFC3339), *version.VersionId, aws.Int64Value(version.Size), *version.Key, latest)})
+	}
+	for _, marker := range versions.DeleteMarkers {
+		if marker.LastModified.Before(since) {
+			continue
+		}
+		latest := ""
+		if *marker.IsLatest {
+			latest = " (latest)"
+		}
+		lines = append(lines, line{*marker.Key, *marker.LastModified, fmt.Sprintf("%s  %-32s  %12s  %s%s",
+			marker.LastModified.UTC().Format(time.RFC3339), *marker.VersionId, "DELETED", *marker.Key, latest)})
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool {
+		if lines[i].key != lines[j].key {
+			return lines[i].key < lines[j].key
+		}
+		return lines[i].lastModified.After(lines[j].lastModified)
+	})
+	for _, l := range lines {
+		fmt.Fprintln(w, l.text)
+	}
+}
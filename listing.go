@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// listingDelimiter partitions the key space for parallel listing.
+const listingDelimiter = "/"
+
+// listVersionsParallel lists the versions under prefix like listAllVersions,
+// but lists every common prefix below it concurrently, with at most
+// parallelism listings in flight. Common prefixes holding more than a page of
+// versions are split again at the next delimiter, so a large directory is
+// spread out too. Keys directly under a prefix are listed along with it, so a
+// flat key space gains nothing. The results are merged back into the order
+// ListObjectVersions returns them in.
+func (s *S3svc) listVersionsParallel(ctx context.Context, bucket, prefix string, parallelism int) (*s3.ListObjectVersionsOutput, error) {
+
+	l := &parallelListing{
+		s:      s,
+		ctx:    ctx,
+		bucket: bucket,
+		slots:  make(chan struct{}, parallelism),
+		result: &s3.ListObjectVersionsOutput{
+			Name:   aws.String(bucket),
+			Prefix: aws.String(prefix),
+		},
+	}
+	l.split(prefix)
+	l.wg.Wait()
+	if l.err != nil {
+		return nil, l.err
+	}
+
+	// Keys directly under a prefix sort among its common prefixes, e.g.
+	// "a.txt" comes before "a/". Each key is listed by one listing, so a
+	// stable sort keeps its versions most recent first.
+	listVersionResp := l.result
+	sort.SliceStable(listVersionResp.Versions, func(i, j int) bool {
+		return *listVersionResp.Versions[i].Key < *listVersionResp.Versions[j].Key
+	})
+	sort.SliceStable(listVersionResp.DeleteMarkers, func(i, j int) bool {
+		return *listVersionResp.DeleteMarkers[i].Key < *listVersionResp.DeleteMarkers[j].Key
+	})
+	return listVersionResp, nil
+}
+
+// parallelListing collects the listings of the partitions of a bucket made by
+// listVersionsParallel.
+type parallelListing struct {
+	s      *S3svc
+	ctx    context.Context
+	bucket string
+	slots  chan struct{}
+	wg     sync.WaitGroup
+
+	lock   sync.Mutex
+	result *s3.ListObjectVersionsOutput
+	err    error
+}
+
+// split lists the keys directly under prefix, and each common prefix below it
+// separately.
+func (l *parallelListing) split(prefix string) {
+	l.run(func() error {
+		listVersionsParams := &s3.ListObjectVersionsInput{
+			Bucket:    aws.String(l.bucket),
+			Prefix:    aws.String(prefix),
+			Delimiter: aws.String(listingDelimiter),
+		}
+		var prefixes []string
+		req, _ := l.s.Svc.ListObjectVersionsRequest(listVersionsParams)
+		err := sendPages(l.ctx, req, func(data interface{}) bool {
+			page := data.(*s3.ListObjectVersionsOutput)
+			l.add(page)
+			for _, commonPrefix := range page.CommonPrefixes {
+				prefixes = append(prefixes, *commonPrefix.Prefix)
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		for _, commonPrefix := range prefixes {
+			l.list(commonPrefix)
+		}
+		return nil
+	})
+}
+
+// list lists the versions under prefix, unless there's more than a page of
+// them, when prefix is split instead.
+func (l *parallelListing) list(prefix string) {
+	l.run(func() error {
+		listVersionsParams := &s3.ListObjectVersionsInput{
+			Bucket: aws.String(l.bucket),
+			Prefix: aws.String(prefix),
+		}
+		req, page := l.s.Svc.ListObjectVersionsRequest(listVersionsParams)
+		if err := send(l.ctx, req); err != nil {
+			return err
+		}
+		if aws.BoolValue(page.IsTruncated) {
+			l.split(prefix)
+			return nil
+		}
+		l.add(page)
+		return nil
+	})
+}
+
+// run calls fn in a goroutine of its own once fewer than parallelism listings
+// are in flight, keeping the first error. Nothing more is listed after one.
+func (l *parallelListing) run(fn func() error) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		l.slots <- struct{}{}
+		defer func() { <-l.slots }()
+
+		l.lock.Lock()
+		failed := l.err != nil
+		l.lock.Unlock()
+		if failed {
+			return
+		}
+		if err := fn(); err != nil {
+			l.lock.Lock()
+			if l.err == nil {
+				l.err = err
+			}
+			l.lock.Unlock()
+		}
+	}()
+}
+
+func (l *parallelListing) add(page *s3.ListObjectVersionsOutput) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.result.Versions = append(l.result.Versions, page.Versions...)
+	l.result.DeleteMarkers = append(l.result.DeleteMarkers, page.DeleteMarkers...)
+}
+
+// KeyVersions holds the versions and delete markers of a single key, most
+// recent first.
+type KeyVersions struct {
+	Key           string
+	Versions      []*s3.ObjectVersion
+	DeleteMarkers []*s3.DeleteMarkerEntry
+}
+
+// keyGrouper splits listings in key order into the versions of each key. A
+// key's versions can span pages, so the last key seen is only passed on once
+// the next one starts, or on flush.
+type keyGrouper struct {
+	fn      func(*KeyVersions) error
+	current *KeyVersions
+	err     error
+}
+
+func (g *keyGrouper) add(page *s3.ListObjectVersionsOutput) bool {
+	versions, markers := page.Versions, page.DeleteMarkers
+	for g.err == nil && (len(versions) > 0 || len(markers) > 0) {
+		key := ""
+		if len(versions) > 0 {
+			key = *versions[0].Key
+		}
+		if len(markers) > 0 && (key == "" || *markers[0].Key < key) {
+			key = *markers[0].Key
+		}
+
+		if g.current == nil || g.current.Key != key {
+			g.flush()
+			g.current = &KeyVersions{Key: key}
+		}
+		for len(versions) > 0 && *versions[0].Key == key {
+			g.current.Versions = append(g.current.Versions, versions[0])
+			versions = versions[1:]
+		}
+		for len(markers) > 0 && *markers[0].Key == key {
+			g.current.DeleteMarkers = append(g.current.DeleteMarkers, markers[0])
+			markers = markers[1:]
+		}
+	}
+	return g.err == nil
+}
+
+func (g *keyGrouper) flush() error {
+	if g.current != nil && g.err == nil {
+		g.err = g.fn(g.current)
+	}
+	g.current = nil
+	return g.err
+}
+
+// WalkVersions calls fn with the versions of each key under prefix, in key
+// order, stopping at the first error. Listed directly, versions are read a
+// page at a time and only the current key's are kept, so memory use doesn't
+// grow with the size of the bucket. Inventory reports, the local index and
+// parallel listing need the whole listing first. Listing stops once ctx is
+// done.
+func (s *S3svc) WalkVersions(ctx context.Context, bucket, prefix string, fn func(*KeyVersions) error) error {
+
+	g := &keyGrouper{fn: fn}
+	if s.Inventory != "" || s.IndexDir != "" || s.ListParallelism > 1 {
+		listVersionResp, err := s.ListVersions(ctx, bucket, prefix)
+		if err != nil {
+			return err
+		}
+		g.add(listVersionResp)
+		return g.flush()
+	}
+
+	listVersionsParams := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	req, _ := s.Svc.ListObjectVersionsRequest(listVersionsParams)
+	err := sendPages(ctx, req, func(data interface{}) bool {
+		return g.add(data.(*s3.ListObjectVersionsOutput))
+	})
+	if err != nil {
+		return err
+	}
+	return g.flush()
+}
@@ -0,0 +1,179 @@
+package main_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Parallel listing", func() {
+	var (
+		fake *fakeS3
+		svc  *S3svc
+	)
+
+	ids := func(versions *s3.ListObjectVersionsOutput) []string {
+		var ids []string
+		for _, v := range versions.Versions {
+			ids = append(ids, *v.VersionId)
+		}
+		for _, m := range versions.DeleteMarkers {
+			ids = append(ids, *m.VersionId)
+		}
+		return ids
+	}
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("a.txt", "a.txt1", 100, true),
+				version("a/1", "a/1-2", 200, true),
+				version("a/1", "a/1-1", 100, false),
+				version("a/2", "a/2-1", 100, false),
+				version("b/c/d", "b/c/d1", 100, true),
+				version("top", "top1", 100, true),
+			},
+			DeleteMarkers: []*s3.DeleteMarkerEntry{
+				deleteMarker("a/2", "a/2-2", 200, true),
+			},
+		}
+		svc = fake.S3svc()
+	})
+
+	It("Lists each common prefix separately", func() {
+		svc.ListParallelism = 4
+		versions, err := svc.ListVersions(context.Background(), "mybucket", "")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fake.Calls["ListObjectVersions"]).To(Equal(3))
+		Expect(ids(versions)).To(Equal([]string{"a.txt1", "a/1-2", "a/1-1", "a/2-1", "b/c/d1", "top1", "a/2-2"}))
+	})
+
+	It("Returns the same listing as a sequential one", func() {
+		sequential, err := svc.ListVersions(context.Background(), "mybucket", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fake.Calls["ListObjectVersions"]).To(Equal(1))
+
+		svc.ListParallelism = 2
+		parallel, err := svc.ListVersions(context.Background(), "mybucket", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parallel).To(Equal(sequential))
+	})
+
+	It("Partitions below the prefix", func() {
+		svc.ListParallelism = 2
+		versions, err := svc.ListVersions(context.Background(), "mybucket", "a")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fake.Calls["ListObjectVersions"]).To(Equal(2))
+		Expect(ids(versions)).To(Equal([]string{"a.txt1", "a/1-2", "a/1-1", "a/2-1", "a/2-2"}))
+	})
+
+	It("Splits prefixes with more than a page of versions again", func() {
+		fake.Listing = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("logs/1/a", "1a", 100, true),
+				version("logs/1/b", "1b", 100, true),
+				version("logs/2/a", "2a", 100, true),
+				version("logs/2/b", "2b", 100, true),
+			},
+		}
+		fake.PageSize = 2
+		sequential, err := svc.ListVersions(context.Background(), "mybucket", "")
+		Expect(err).ToNot(HaveOccurred())
+
+		fake.Calls["ListObjectVersions"] = 0
+		svc.ListParallelism = 4
+		parallel, err := svc.ListVersions(context.Background(), "mybucket", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parallel).To(Equal(sequential))
+		// The top level, a page of logs/, logs/ split, then logs/1/ and logs/2/.
+		Expect(fake.Calls["ListObjectVersions"]).To(Equal(5))
+	})
+})
+
+var _ = Describe("Walking versions", func() {
+	var (
+		fake *fakeS3
+		svc  *S3svc
+	)
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("a", "a3", 300, false),
+				version("a", "a2", 200, false),
+				version("a", "a1", 100, false),
+				version("b", "b2", 200, true),
+				version("b", "b1", 100, false),
+				version("c", "c1", 100, true),
+			},
+			DeleteMarkers: []*s3.DeleteMarkerEntry{
+				deleteMarker("a", "a4", 400, true),
+			},
+		}
+		fake.PageSize = 2
+		svc = fake.S3svc()
+	})
+
+	It("Passes on each key's versions once, across pages", func() {
+		var keys []string
+		var versions [][]string
+		err := svc.WalkVersions(context.Background(), "mybucket", "", func(key *KeyVersions) error {
+			keys = append(keys, key.Key)
+			var ids []string
+			for _, v := range key.Versions {
+				ids = append(ids, *v.VersionId)
+			}
+			for _, m := range key.DeleteMarkers {
+				ids = append(ids, *m.VersionId)
+			}
+			versions = append(versions, ids)
+			return nil
+		})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fake.Calls["ListObjectVersions"]).To(Equal(4))
+		Expect(keys).To(Equal([]string{"a", "b", "c"}))
+		Expect(versions).To(Equal([][]string{{"a3", "a2", "a1", "a4"}, {"b2", "b1"}, {"c1"}}))
+	})
+
+	It("Stops listing at the first error", func() {
+		err := svc.WalkVersions(context.Background(), "mybucket", "", func(key *KeyVersions) error {
+			return fmt.Errorf("stop at %s", key.Key)
+		})
+
+		Expect(err).To(MatchError("stop at a"))
+		Expect(fake.Calls["ListObjectVersions"]).To(Equal(3))
+	})
+
+	It("Restores a key at a time", func() {
+		err := svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fake.Copied).To(Equal([]string{"a1", "b1"}))
+	})
+
+	It("Plans the same versions as a full listing", func() {
+		var planned []*s3.ObjectVersion
+		err := svc.WalkPlan(context.Background(), "mybucket", "", time.Unix(250, 0), func(version *s3.ObjectVersion, replaced string) error {
+			planned = append(planned, version)
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		listing, err := svc.ListVersions(context.Background(), "mybucket", "")
+		Expect(err).ToNot(HaveOccurred())
+		plan, err := svc.PlanRestore(context.Background(), "mybucket", listing, time.Unix(250, 0))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(planned).To(Equal(plan))
+	})
+})
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if name == levelName {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("%q is not a log level, must be one of %s", name, strings.Join(levelNames, ", "))
+}
+
+// Logger writes levelled log lines with key/value fields, either as text
+//
+//	2006-01-02T15:04:05Z INFO restored bucket=mybucket key="a b"
+//
+// or as one JSON object a line. A nil Logger discards everything.
+type Logger struct {
+	w     io.Writer
+	level Level
+	json  bool
+	lock  sync.Mutex
+	// live is the line ShowLive keeps at the bottom of the terminal.
+	live string
+}
+
+// NewLogger returns a logger writing lines of level and above to w, in the
+// "text" or "json" format.
+func NewLogger(w io.Writer, level Level, format string) (*Logger, error) {
+	if format != "text" && format != "json" {
+		return nil, fmt.Errorf("%q is not a log format, must be text or json", format)
+	}
+	return &Logger{w: w, level: level, json: format == "json"}, nil
+}
+
+// addLogFlags adds the logging options to command. The returned function
+// copies their values into the parsed arguments.
+func addLogFlags(command *flag.FlagSet) func(args map[string]string) map[string]string {
+	level := command.String("log-level", "info", "Least severe messages to log: debug, info, warn or error.")
+	format := command.String("log-format", "text", "Format of log lines: text or json.")
+
+	return func(args map[string]string) map[string]string {
+		args["log-level"] = *level
+		args["log-format"] = *format
+		return args
+	}
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) { l.log(LevelDebug, msg, keyvals) }
+func (l *Logger) Info(msg string, keyvals ...interface{})  { l.log(LevelInfo, msg, keyvals) }
+func (l *Logger) Warn(msg string, keyvals ...interface{})  { l.log(LevelWarn, msg, keyvals) }
+func (l *Logger) Error(msg string, keyvals ...interface{}) { l.log(LevelError, msg, keyvals) }
+
+func (l *Logger) log(level Level, msg string, keyvals []interface{}) {
+	if l == nil || level < l.level {
+		return
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	var b bytes.Buffer
+	if l.json {
+		b.WriteString(`{"time":`)
+		writeJSON(&b, now)
+		b.WriteString(`,"level":`)
+		writeJSON(&b, level.String())
+		b.WriteString(`,"msg":`)
+		writeJSON(&b, msg)
+		for i := 0; i+1 < len(keyvals); i += 2 {
+			b.WriteByte(',')
+			writeJSON(&b, fmt.Sprint(keyvals[i]))
+			b.WriteByte(':')
+			writeJSON(&b, jsonValue(keyvals[i+1]))
+		}
+		b.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&b, "%s %s %s", now, strings.ToUpper(level.String()), msg)
+		for i := 0; i+1 < len(keyvals); i += 2 {
+			fmt.Fprintf(&b, " %s=%s", keyvals[i], textValue(keyvals[i+1]))
+		}
+		b.WriteByte('\n')
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.live != "" {
+		// Write over the live line, then put it back below.
+		l.w.Write([]byte("\r\x1b[K"))
+		l.w.Write(b.Bytes())
+		fmt.Fprintf(l.w, "\r%s\x1b[K", l.live)
+		return
+	}
+	l.w.Write(b.Bytes())
+}
+
+// ShowLive shows line at the bottom of a terminal, rewriting whatever live
+// line was there. Lines logged while it's shown are written above it.
+// ShowLive("") leaves the last line shown and logs below it again.
+func (l *Logger) ShowLive(line string) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if line == "" {
+		if l.live != "" {
+			fmt.Fprintln(l.w)
+		}
+		l.live = ""
+		return
+	}
+	l.live = line
+	fmt.Fprintf(l.w, "\r%s\x1b[K", line)
+}
+
+func writeJSON(b *bytes.Buffer, value interface{}) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		encoded, _ = json.Marshal(fmt.Sprint(value))
+	}
+	b.Write(encoded)
+}
+
+// jsonValue turns durations into seconds, and errors and other values which
+// describe themselves into strings.
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Duration:
+		return v.Seconds()
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return value
+}
+
+// textValue formats a field value, quoting it if it has spaces, quotes or
+// equals signs in, or is empty.
+func textValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case error:
+		s = v.Error()
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " \"=\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,110 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Logger", func() {
+
+	It("Writes text lines, quoting values where needed", func() {
+		var out bytes.Buffer
+		logger, err := NewLogger(&out, LevelInfo, "text")
+		Expect(err).ToNot(HaveOccurred())
+
+		logger.Info("restored", "key", "a b", "version_id", "v1", "empty", "", "error", errors.New("x=y"))
+
+		Expect(out.String()).To(MatchRegexp(`^\S+Z INFO restored key="a b" version_id=v1 empty="" error="x=y"\n$`))
+	})
+
+	It("Writes JSON lines", func() {
+		var out bytes.Buffer
+		logger, err := NewLogger(&out, LevelDebug, "json")
+		Expect(err).ToNot(HaveOccurred())
+
+		logger.Warn("slow", "key", "a", "duration", 1500*time.Millisecond, "error", errors.New("boom"))
+
+		var line map[string]interface{}
+		Expect(json.Unmarshal(out.Bytes(), &line)).To(Succeed())
+		Expect(line).To(HaveKeyWithValue("level", "warn"))
+		Expect(line).To(HaveKeyWithValue("msg", "slow"))
+		Expect(line).To(HaveKeyWithValue("key", "a"))
+		Expect(line).To(HaveKeyWithValue("duration", 1.5))
+		Expect(line).To(HaveKeyWithValue("error", "boom"))
+		Expect(line).To(HaveKey("time"))
+	})
+
+	It("Leaves out lines below its level", func() {
+		var out bytes.Buffer
+		logger, _ := NewLogger(&out, LevelWarn, "text")
+
+		logger.Debug("debug")
+		logger.Info("info")
+		logger.Error("error")
+
+		Expect(out.String()).To(ContainSubstring("ERROR error"))
+		Expect(out.String()).ToNot(ContainSubstring("info"))
+		Expect(out.String()).ToNot(ContainSubstring("debug"))
+
+		var nilLogger *Logger
+		nilLogger.Info("discarded")
+	})
+
+	It("Rejects unknown levels and formats", func() {
+		_, err := ParseLevel("loud")
+		Expect(err).To(MatchError(`"loud" is not a log level, must be one of debug, info, warn, error`))
+		level, err := ParseLevel("debug")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(level).To(Equal(LevelDebug))
+
+		_, err = NewLogger(&bytes.Buffer{}, LevelInfo, "xml")
+		Expect(err).To(MatchError(`"xml" is not a log format, must be text or json`))
+	})
+
+	It("Logs what a restore does", func() {
+		fake := newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("a", "a2", 200, true),
+				version("a", "a1", 100, false),
+				version("b", "b2", 200, true),
+				version("b", "b1", 100, false),
+			},
+		}
+		fake.CopyFailures["b1"] = []int{403}
+		svc := fake.S3svc()
+		var out bytes.Buffer
+		svc.Log, _ = NewLogger(&out, LevelInfo, "json")
+
+		Expect(svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))).ToNot(Succeed())
+
+		var lines []map[string]interface{}
+		for _, text := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			var line map[string]interface{}
+			Expect(json.Unmarshal([]byte(text), &line)).To(Succeed())
+			lines = append(lines, line)
+		}
+		find := func(msg, key string) map[string]interface{} {
+			for _, line := range lines {
+				if line["msg"] == msg && line["key"] == key {
+					return line
+				}
+			}
+			return nil
+		}
+		Expect(find("restored", "a")).To(HaveKeyWithValue("action", "restore"))
+		Expect(find("restored", "a")).To(HaveKeyWithValue("version_id", "a1"))
+		Expect(find("restore failed", "b")).To(HaveKeyWithValue("level", "error"))
+		Expect(find("restore failed", "b")).To(HaveKeyWithValue("class", ErrorPermission))
+	})
+})
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// copyLatencyBuckets are the upper bounds, in seconds, of the copy latency
+// histogram's buckets.
+var copyLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+// apiCall labels the API call counter.
+type apiCall struct {
+	Operation string
+	Status    string
+}
+
+// Metrics counts what a command does, in the Prometheus text exposition
+// format. A nil Metrics counts nothing.
+type Metrics struct {
+	lock sync.Mutex
+
+	start         time.Time
+	end           time.Time
+	success       bool
+	restored      int64
+	restoredBytes int64
+	failed        int64
+	failedBytes   int64
+	apiCalls      map[apiCall]int64
+	retries       map[string]int64
+
+	latencyCounts []int64
+	latencyCount  int64
+	latencySum    float64
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		start:         time.Now(),
+		apiCalls:      make(map[apiCall]int64),
+		retries:       make(map[string]int64),
+		latencyCounts: make([]int64, len(copyLatencyBuckets)),
+	}
+}
+
+// addMetricsFlags adds the metrics options to command. The returned function
+// copies their values into the parsed arguments.
+func addMetricsFlags(command *flag.FlagSet) func(args map[string]string) map[string]string {
+	listen := command.String("metrics-listen", "", "Address to serve Prometheus metrics on at /metrics while running, e.g. :9100. Default none.")
+	textfile := command.String("metrics-textfile", "", "File to write Prometheus metrics to when finished, for the node exporter's textfile collector. Default none.")
+
+	return func(args map[string]string) map[string]string {
+		args["metrics-listen"] = *listen
+		args["metrics-textfile"] = *textfile
+		return args
+	}
+}
+
+func (m *Metrics) update(f func()) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	f()
+}
+
+// Instrument counts every request svc sends, and every retry.
+func (m *Metrics) Instrument(svc *s3.S3) {
+	if m == nil {
+		return
+	}
+	svc.Handlers.Send.PushBack(func(r *request.Request) {
+		status := "error"
+		if r.Error == nil && r.HTTPResponse != nil {
+			status = strconv.Itoa(r.HTTPResponse.StatusCode)
+		}
+		m.update(func() { m.apiCalls[apiCall{Operation: r.Operation.Name, Status: status}]++ })
+	})
+	// The SDK's own handler has run by now, and cleared the error if the
+	// request is to be retried.
+	svc.Handlers.AfterRetry.PushBack(func(r *request.Request) {
+		if r.Error == nil {
+			m.update(func() { m.retries[r.Operation.Name]++ })
+		}
+	})
+}
+
+// ObserveCopy counts a copy of bytes which took d, and failed if err is set.
+func (m *Metrics) ObserveCopy(bytes int64, d time.Duration, err error) {
+	m.update(func() {
+		if err != nil {
+			m.failed++
+			m.failedBytes += bytes
+		} else {
+			m.restored++
+			m.restoredBytes += bytes
+		}
+		seconds := d.Seconds()
+		for i, bound := range copyLatencyBuckets {
+			if seconds <= bound {
+				m.latencyCounts[i]++
+			}
+		}
+		m.latencyCount++
+		m.latencySum += seconds
+	})
+}
+
+// Finish records when the command finished, and whether it succeeded.
+func (m *Metrics) Finish(err error) {
+	m.update(func() {
+		m.end = time.Now()
+		m.success = err == nil
+	})
+}
+
+func writeHeader(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var b bytes.Buffer
+	single := func(name, kind, help string, value interface{}) {
+		writeHeader(&b, name, kind, help)
+		fmt.Fprintf(&b, "%s %v\n", name, value)
+	}
+
+	single("s3r_start_time_seconds", "gauge", "When the command started, in seconds since the epoch.", unixSeconds(m.start))
+	if !m.end.IsZero() {
+		single("s3r_end_time_seconds", "gauge", "When the command finished, in seconds since the epoch.", unixSeconds(m.end))
+		success := 0
+		if m.success {
+			success = 1
+		}
+		single("s3r_success", "gauge", "Whether the command succeeded.", success)
+	}
+	single("s3r_objects_restored_total", "counter", "Objects restored.", m.restored)
+	single("s3r_bytes_restored_total", "counter", "Bytes of objects restored.", m.restoredBytes)
+	single("s3r_objects_failed_total", "counter", "Objects which couldn't be restored.", m.failed)
+	single("s3r_bytes_failed_total", "counter", "Bytes of objects which couldn't be restored.", m.failedBytes)
+
+	calls := make([]apiCall, 0, len(m.apiCalls))
+	for call := range m.apiCalls {
+		calls = append(calls, call)
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		if calls[i].Operation != calls[j].Operation {
+			return calls[i].Operation < calls[j].Operation
+		}
+		return calls[i].Status < calls[j].Status
+	})
+	writeHeader(&b, "s3r_api_calls_total", "counter", "S3 API requests sent, by operation and HTTP status.")
+	for _, call := range calls {
+		fmt.Fprintf(&b, "s3r_api_calls_total{operation=%q,status=%q} %d\n", call.Operation, call.Status, m.apiCalls[call])
+	}
+
+	operations := make([]string, 0, len(m.retries))
+	for operation := range m.retries {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	writeHeader(&b, "s3r_retries_total", "counter", "S3 API requests retried, by operation.")
+	for _, operation := range operations {
+		fmt.Fprintf(&b, "s3r_retries_total{operation=%q} %d\n", operation, m.retries[operation])
+	}
+
+	writeHeader(&b, "s3r_copy_duration_seconds", "histogram", "How long restoring an object took, including retries.")
+	for i, bound := range copyLatencyBuckets {
+		fmt.Fprintf(&b, "s3r_copy_duration_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), m.latencyCounts[i])
+	}
+	fmt.Fprintf(&b, "s3r_copy_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.latencyCount)
+	fmt.Fprintf(&b, "s3r_copy_duration_seconds_sum %v\n", m.latencySum)
+	fmt.Fprintf(&b, "s3r_copy_duration_seconds_count %d\n", m.latencyCount)
+
+	n, err := w.Write(b.Bytes())
+	return int64(n), err
+}
+
+// ServeHTTP serves the metrics to Prometheus.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteTo(w)
+}
+
+// Serve serves the metrics at /metrics on address, until the process exits.
+func (m *Metrics) Serve(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	go http.Serve(listener, mux)
+	return nil
+}
+
+// WriteTextfile writes the metrics to path, for the node exporter's textfile
+// collector. It writes a temporary file and renames it so the collector never
+// reads half a file.
+func (m *Metrics) WriteTextfile(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := m.WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
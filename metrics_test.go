@@ -0,0 +1,129 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Metrics", func() {
+
+	It("Counts copies, API calls and retries", func() {
+		fake := newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("a", "a2", 200, true),
+				version("a", "a1", 100, false),
+				version("b", "b2", 200, true),
+				version("b", "b1", 100, false),
+			},
+		}
+		fake.CopyFailures["a1"] = []int{503}
+		fake.CopyFailures["b1"] = []int{403}
+		svc := fake.S3svc()
+		svc.Metrics = NewMetrics()
+		svc.Metrics.Instrument(svc.Svc)
+
+		Expect(svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))).ToNot(Succeed())
+
+		var out bytes.Buffer
+		_, err := svc.Metrics.WriteTo(&out)
+		Expect(err).ToNot(HaveOccurred())
+		metrics := out.String()
+		Expect(metrics).To(ContainSubstring("# TYPE s3r_objects_restored_total counter\ns3r_objects_restored_total 1\n"))
+		Expect(metrics).To(ContainSubstring("s3r_bytes_restored_total 10\n"))
+		Expect(metrics).To(ContainSubstring("s3r_objects_failed_total 1\n"))
+		Expect(metrics).To(ContainSubstring(`s3r_api_calls_total{operation="CopyObject",status="200"} 1` + "\n"))
+		Expect(metrics).To(ContainSubstring(`s3r_api_calls_total{operation="CopyObject",status="403"} 1` + "\n"))
+		Expect(metrics).To(ContainSubstring(`s3r_api_calls_total{operation="CopyObject",status="503"} 1` + "\n"))
+		Expect(metrics).To(ContainSubstring(`s3r_api_calls_total{operation="ListObjectVersions",status="200"} 1` + "\n"))
+		Expect(metrics).To(ContainSubstring(`s3r_retries_total{operation="CopyObject"} 1` + "\n"))
+		Expect(metrics).To(ContainSubstring("# TYPE s3r_copy_duration_seconds histogram\n"))
+		Expect(metrics).To(ContainSubstring(`s3r_copy_duration_seconds_bucket{le="+Inf"} 2` + "\n"))
+		Expect(metrics).To(ContainSubstring("s3r_copy_duration_seconds_count 2\n"))
+		Expect(metrics).ToNot(ContainSubstring("s3r_success"))
+	})
+
+	It("Counts the copies of rollbacks", func() {
+		fake := newFakeS3()
+		fake.CopyFailures["b1"] = []int{403}
+		svc := fake.S3svc()
+		svc.Metrics = NewMetrics()
+		versions := &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("a", "a2", 200, true),
+				version("a", "a1", 100, false),
+				version("b", "b2", 200, true),
+				version("b", "b1", 100, false),
+			},
+		}
+
+		_, err := svc.RollbackObjects(context.Background(), "mybucket", versions, time.Unix(150, 0), time.Unix(250, 0))
+		Expect(err).To(HaveOccurred())
+
+		var out bytes.Buffer
+		svc.Metrics.WriteTo(&out)
+		Expect(out.String()).To(ContainSubstring("s3r_objects_restored_total 1\n"))
+		Expect(out.String()).To(ContainSubstring("s3r_bytes_restored_total 10\n"))
+		Expect(out.String()).To(ContainSubstring("s3r_objects_failed_total 1\n"))
+		Expect(out.String()).To(ContainSubstring("s3r_copy_duration_seconds_count 2\n"))
+	})
+
+	It("Puts copies in latency buckets", func() {
+		metrics := NewMetrics()
+		metrics.ObserveCopy(1, 200*time.Millisecond, nil)
+		metrics.ObserveCopy(1, 3*time.Second, nil)
+
+		var out bytes.Buffer
+		metrics.WriteTo(&out)
+		Expect(out.String()).To(ContainSubstring(`s3r_copy_duration_seconds_bucket{le="0.1"} 0` + "\n"))
+		Expect(out.String()).To(ContainSubstring(`s3r_copy_duration_seconds_bucket{le="0.25"} 1` + "\n"))
+		Expect(out.String()).To(ContainSubstring(`s3r_copy_duration_seconds_bucket{le="5"} 2` + "\n"))
+		Expect(out.String()).To(ContainSubstring("s3r_copy_duration_seconds_sum 3.2\n"))
+
+		var nilMetrics *Metrics
+		nilMetrics.ObserveCopy(1, time.Second, nil)
+	})
+
+	It("Serves metrics over HTTP", func() {
+		metrics := NewMetrics()
+		metrics.ObserveCopy(5, time.Second, nil)
+
+		recorder := httptest.NewRecorder()
+		metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+		Expect(recorder.Header().Get("Content-Type")).To(Equal("text/plain; version=0.0.4"))
+		Expect(recorder.Body.String()).To(ContainSubstring("s3r_bytes_restored_total 5\n"))
+	})
+
+	It("Writes a textfile for the node exporter when finished", func() {
+		dir, err := ioutil.TempDir("", "metrics")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		metrics := NewMetrics()
+		metrics.Finish(errors.New("failed"))
+		path := filepath.Join(dir, "s3r.prom")
+		Expect(metrics.WriteTextfile(path)).To(Succeed())
+
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(contents)).To(ContainSubstring("s3r_success 0\n"))
+		Expect(string(contents)).To(ContainSubstring("s3r_end_time_seconds "))
+
+		files, err := ioutil.ReadDir(dir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+	})
+})
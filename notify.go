@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Event is what's sent to a webhook when a restore starts, passes a
+// milestone, completes or fails.
+type Event struct {
+	Event     string       `json:"event"`
+	Command   string       `json:"command"`
+	Bucket    string       `json:"bucket"`
+	Prefix    string       `json:"prefix"`
+	Timestamp time.Time    `json:"timestamp"`
+	Time      time.Time    `json:"time"`
+	Percent   int          `json:"percent,omitempty"`
+	Counts    ReportCounts `json:"counts"`
+	Bytes     ReportBytes  `json:"bytes"`
+	Error     string       `json:"error,omitempty"`
+}
+
+// Text describes the event in a sentence, for chat messages.
+func (e Event) Text() string {
+	what := fmt.Sprintf("s3r %s of s3://%s/%s to %s", e.Command, e.Bucket, e.Prefix, e.Timestamp.Format(time.RFC3339))
+	summary := fmt.Sprintf("%d restored, %d skipped, %d failed, %s restored",
+		e.Counts.Restored, e.Counts.Skipped, e.Counts.Failed, humanBytes(float64(e.Bytes.Restored)))
+	switch e.Event {
+	case "started":
+		return what + " started"
+	case "progress":
+		return fmt.Sprintf("%s is %d%% done: %s", what, e.Percent, summary)
+	case "completed":
+		return fmt.Sprintf("%s completed: %s", what, summary)
+	}
+	return fmt.Sprintf("%s failed: %s (%s)", what, e.Error, summary)
+}
+
+var notifyTemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	},
+}
+
+// slackTemplate posts events as Slack incoming webhook messages.
+const slackTemplate = `{"text": {{json .Text}}}`
+
+// notifyMilestones are the percentages of planned objects done which are
+// notified.
+var notifyMilestones = []int{25, 50, 75}
+
+// Notifier POSTs events to a webhook. Deliveries which fail are retried, and
+// if they still fail are logged rather than failing the restore. A nil
+// Notifier sends nothing.
+type Notifier struct {
+	URL        string
+	Client     *http.Client
+	Attempts   int
+	RetryDelay time.Duration
+	Log        *Logger
+
+	// Base is copied into every event sent.
+	Base Event
+
+	template *template.Template
+}
+
+// NewNotifier returns a notifier POSTing to url. The body is the event as
+// JSON, unless templateFile is "slack" for a Slack message, or names a file
+// with a text/template of the body, executed with the event.
+func NewNotifier(url, templateFile string) (*Notifier, error) {
+	n := &Notifier{URL: url, Client: &http.Client{Timeout: 10 * time.Second}, Attempts: 3, RetryDelay: time.Second}
+	text := slackTemplate
+	switch templateFile {
+	case "":
+		return n, nil
+	case "slack":
+	default:
+		contents, err := ioutil.ReadFile(templateFile)
+		if err != nil {
+			return nil, err
+		}
+		text = string(contents)
+	}
+	var err error
+	if n.template, err = template.New("notify").Funcs(notifyTemplateFuncs).Parse(text); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// Notify sends an event of kind, with what p has counted so far and err, if
+// any.
+func (n *Notifier) Notify(kind string, p *Progress, err error) {
+	if n == nil {
+		return
+	}
+	event := n.event(kind, p)
+	if err != nil {
+		event.Error = err.Error()
+	}
+	n.send(event)
+}
+
+func (n *Notifier) event(kind string, p *Progress) Event {
+	event := n.Base
+	event.Event = kind
+	event.Time = time.Now().UTC()
+	event.Counts, event.Bytes = reportCounts(p)
+	return event
+}
+
+// webhookHost returns the scheme and host of a webhook URL, which is all of it
+// that's logged: the rest of a Slack webhook's URL is its secret.
+func webhookHost(webhook string) string {
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func (n *Notifier) send(event Event) {
+	host := webhookHost(n.URL)
+	var body bytes.Buffer
+	var err error
+	if n.template != nil {
+		err = n.template.Execute(&body, event)
+	} else {
+		err = json.NewEncoder(&body).Encode(event)
+	}
+	if err != nil {
+		n.Log.Error("failed to notify", "event", event.Event, "host", host, "error", err)
+		return
+	}
+
+	delay := n.RetryDelay
+	for attempt := 1; ; attempt++ {
+		err = n.post(body.Bytes())
+		if err == nil {
+			n.Log.Debug("notified", "event", event.Event, "host", host)
+			return
+		}
+		if attempt >= n.Attempts {
+			break
+		}
+		n.Log.Warn("failed to notify, retrying", "event", event.Event, "host", host, "attempt", attempt, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+	n.Log.Error("failed to notify", "event", event.Event, "host", host, "error", err)
+}
+
+func (n *Notifier) post(body []byte) error {
+	resp, err := n.Client.Post(n.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		if urlErr, ok := err.(*url.Error); ok {
+			urlErr.URL = webhookHost(urlErr.URL)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// WatchProgress sends a progress event as each milestone in the objects
+// planned is done, checking every interval, until the returned function is
+// called. Milestones are only known once listing is done.
+func (n *Notifier) WatchProgress(p *Progress, interval time.Duration) (stop func()) {
+	if n == nil || p == nil {
+		return func() {}
+	}
+
+	next := 0
+	check := func() {
+		snapshot, _ := p.Snapshot()
+		if !snapshot.ListingDone || snapshot.Planned == 0 {
+			return
+		}
+		percent := int((snapshot.Copied + snapshot.Failed) * 100 / snapshot.Planned)
+		reached := -1
+		for next < len(notifyMilestones) && percent >= notifyMilestones[next] {
+			reached = notifyMilestones[next]
+			next++
+		}
+		// Only the latest of several milestones passed at once is sent.
+		if reached >= 0 {
+			event := n.event("progress", p)
+			event.Percent = reached
+			n.send(event)
+		}
+	}
+
+	done := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				check()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
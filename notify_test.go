@@ -0,0 +1,185 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// webhook stands in for a webhook, failing the first Failures requests.
+type webhook struct {
+	lock     sync.Mutex
+	Failures int
+	Requests int
+	Bodies   []string
+}
+
+func (h *webhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.Requests++
+	if h.Failures > 0 {
+		h.Failures--
+		http.Error(w, "try later", http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	h.Bodies = append(h.Bodies, string(body))
+}
+
+func (h *webhook) Events() []Event {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	var events []Event
+	for _, body := range h.Bodies {
+		var event Event
+		Expect(json.Unmarshal([]byte(body), &event)).To(Succeed())
+		events = append(events, event)
+	}
+	return events
+}
+
+var _ = Describe("Notifications", func() {
+	var (
+		hook   *webhook
+		server *httptest.Server
+	)
+
+	BeforeEach(func() {
+		hook = &webhook{}
+		server = httptest.NewServer(hook)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newNotifier := func(template string) *Notifier {
+		notifier, err := NewNotifier(server.URL, template)
+		Expect(err).ToNot(HaveOccurred())
+		notifier.RetryDelay = time.Millisecond
+		notifier.Base = Event{Command: "restore", Bucket: "mybucket", Prefix: "logs/", Timestamp: time.Unix(150, 0).UTC()}
+		return notifier
+	}
+
+	It("Posts events as JSON", func() {
+		notifier := newNotifier("")
+		progress := NewProgress()
+		progress.AddPlanned(10)
+		progress.AddCopied(10)
+
+		notifier.Notify("started", nil, nil)
+		notifier.Notify("failed", progress, errors.New("1 of 2 objects couldn't be restored"))
+
+		events := hook.Events()
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Event).To(Equal("started"))
+		Expect(events[0].Bucket).To(Equal("mybucket"))
+		Expect(events[1].Event).To(Equal("failed"))
+		Expect(events[1].Error).To(Equal("1 of 2 objects couldn't be restored"))
+		Expect(events[1].Counts.Restored).To(BeEquivalentTo(1))
+		Expect(events[1].Bytes.Restored).To(BeEquivalentTo(10))
+	})
+
+	It("Retries failed deliveries, then gives up", func() {
+		var out bytes.Buffer
+		notifier := newNotifier("")
+		notifier.Log, _ = NewLogger(&out, LevelInfo, "text")
+
+		hook.Failures = 2
+		notifier.Notify("completed", nil, nil)
+		Expect(hook.Requests).To(Equal(3))
+		Expect(hook.Events()).To(HaveLen(1))
+
+		hook.Failures = 3
+		notifier.Notify("completed", nil, nil)
+		Expect(hook.Requests).To(Equal(6))
+		Expect(hook.Events()).To(HaveLen(1))
+		Expect(out.String()).To(ContainSubstring(`ERROR failed to notify event=completed`))
+		Expect(out.String()).To(ContainSubstring(`error="503 Service Unavailable: try later"`))
+	})
+
+	It("Logs only the webhook's host", func() {
+		var out bytes.Buffer
+		notifier := newNotifier("")
+		notifier.Log, _ = NewLogger(&out, LevelDebug, "text")
+		notifier.URL = server.URL + "/services/T000/B000/secret"
+		notifier.Notify("completed", nil, nil)
+
+		server.Close()
+		notifier.Attempts = 1
+		notifier.Notify("completed", nil, nil)
+
+		Expect(out.String()).To(ContainSubstring("host=" + server.URL))
+		Expect(out.String()).ToNot(ContainSubstring("secret"))
+	})
+
+	It("Posts Slack messages", func() {
+		notifier := newNotifier("slack")
+		progress := NewProgress()
+		progress.AddPlanned(2048)
+		progress.AddCopied(2048)
+		progress.AddSkipped()
+
+		notifier.Notify("completed", progress, nil)
+
+		Expect(hook.Bodies).To(HaveLen(1))
+		Expect(hook.Bodies[0]).To(MatchJSON(`{"text": "s3r restore of s3://mybucket/logs/ to 1970-01-01T00:02:30Z completed: 1 restored, 1 skipped, 0 failed, 2.0 KiB restored"}`))
+	})
+
+	It("Posts bodies from a template file", func() {
+		f, err := ioutil.TempFile("", "template")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Remove(f.Name())
+		f.WriteString(`{"status": {{json .Event}}, "summary": {{json .Text}}}`)
+		f.Close()
+
+		notifier := newNotifier(f.Name())
+		notifier.Notify("started", nil, nil)
+
+		Expect(hook.Bodies).To(HaveLen(1))
+		Expect(hook.Bodies[0]).To(MatchJSON(`{"status": "started", "summary": "s3r restore of s3://mybucket/logs/ to 1970-01-01T00:02:30Z started"}`))
+
+		_, err = NewNotifier(server.URL, "/does/not/exist")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Posts progress milestones once listing is done", func() {
+		notifier := newNotifier("")
+		progress := NewProgress()
+		for i := 0; i < 4; i++ {
+			progress.AddPlanned(1)
+		}
+		stop := notifier.WatchProgress(progress, 5*time.Millisecond)
+
+		progress.AddCopied(1)
+		time.Sleep(20 * time.Millisecond)
+		Expect(hook.Events()).To(BeEmpty())
+
+		progress.DoneListing()
+		Eventually(hook.Events).Should(HaveLen(1))
+
+		progress.AddCopied(1)
+		progress.AddFailed(1)
+		Eventually(hook.Events).Should(HaveLen(2))
+		time.Sleep(20 * time.Millisecond)
+		stop()
+
+		events := hook.Events()
+		Expect(events).To(HaveLen(2))
+		Expect(events[0].Event).To(Equal("progress"))
+		Expect(events[0].Percent).To(Equal(25))
+		Expect(events[1].Percent).To(Equal(75))
+		Expect(events[1].Counts.Failed).To(BeEquivalentTo(1))
+	})
+})
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// PlanWriter writes the versions a restore would copy as they're planned,
+// and on Close how much that is.
+type PlanWriter struct {
+	w       io.Writer
+	Objects int
+	Bytes   int64
+}
+
+func NewPlanWriter(w io.Writer) *PlanWriter {
+	return &PlanWriter{w: w}
+}
+
+func (p *PlanWriter) Add(version *s3.ObjectVersion) {
+	fmt.Fprintf(p.w, "%s  %-32s  %12d  %s\n",
+		version.LastModified.UTC().Format(time.RFC3339), *version.VersionId, aws.Int64Value(version.Size), *version.Key)
+	p.Objects++
+	p.Bytes += aws.Int64Value(version.Size)
+}
+
+func (p *PlanWriter) Close() {
+	fmt.Fprintf(p.w, "%d objects, %d bytes to restore\n", p.Objects, p.Bytes)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Failure is a version which couldn't be restored. Class is one of
+// ErrorRetryable, when it kept failing however often it was retried,
+// ErrorPermanent or ErrorPermission.
+type Failure struct {
+	Key       string
+	VersionId string
+	Class     string
+	Err       error
+}
+
+// copyPool restores versions on as many workers as the Limiter ever allows,
+// each copy waiting for the Limiter and the request and byte rate limits.
+// Failed copies are recorded in Failures rather than stopping the others.
+// Once ctx is done no more versions are accepted.
+type copyPool struct {
+	s      *S3svc
+	ctx    context.Context
+	bucket string
+	work   chan copyJob
+	wg     sync.WaitGroup
+
+	lock      sync.Mutex
+	submitted int
+	failed    int
+}
+
+func (s *S3svc) startCopies(ctx context.Context, bucket string) *copyPool {
+
+	if s.Limiter == nil {
+		s.Limiter = NewLimiter(1)
+	}
+	p := &copyPool{s: s, ctx: ctx, bucket: bucket, work: make(chan copyJob)}
+	for i := 0; i < s.Limiter.Max(); i++ {
+		p.wg.Add(1)
+		go p.worker()
+	}
+	return p
+}
+
+func (p *copyPool) worker() {
+	defer p.wg.Done()
+	for job := range p.work {
+		version := job.version
+		p.s.RequestRate.Take(*version.Key, 1)
+		p.s.ByteRate.Take(*version.Key, float64(aws.Int64Value(version.Size)))
+		p.s.Limiter.Acquire()
+		copyResp, err := p.s.copyVersion(p.ctx, p.bucket, *version.Key, *version.VersionId, aws.Int64Value(version.Size))
+		p.s.Limiter.Release(err == nil)
+		if err != nil {
+			p.s.recordFailure("restore", p.bucket, *version.Key, *version.VersionId, aws.Int64Value(version.Size), err)
+			p.lock.Lock()
+			p.failed++
+			p.lock.Unlock()
+			continue
+		}
+		p.s.Progress.AddCopied(aws.Int64Value(version.Size))
+		p.s.addRestored(VersionRestore{Key: *version.Key, VersionId: *version.VersionId, NewVersionId: aws.StringValue(copyResp.VersionId),
+			ReplacedVersionId: job.replaced})
+	}
+}
+
+// copyJob is a version to restore over replaced, the latest version of its
+// key.
+type copyJob struct {
+	version  *s3.ObjectVersion
+	replaced string
+}
+
+// Submit queues version to be restored over replaced, waiting while every
+// worker is busy. It returns a CanceledError, without queueing version, once
+// ctx is done.
+func (p *copyPool) Submit(version *s3.ObjectVersion, replaced string) error {
+	if err := canceled(p.ctx); err != nil {
+		return err
+	}
+	select {
+	case p.work <- copyJob{version: version, replaced: replaced}:
+	case <-p.ctx.Done():
+		return canceled(p.ctx)
+	}
+	p.lock.Lock()
+	p.submitted++
+	p.lock.Unlock()
+	return nil
+}
+
+// Wait lets the queued copies finish. It fails if any of them did.
+func (p *copyPool) Wait() error {
+	close(p.work)
+	p.wg.Wait()
+
+	p.s.resultLock.Lock()
+	sort.SliceStable(p.s.Restored, func(i, j int) bool {
+		return p.s.Restored[i].Key < p.s.Restored[j].Key
+	})
+	sort.SliceStable(p.s.Failures, func(i, j int) bool {
+		return p.s.Failures[i].Key < p.s.Failures[j].Key
+	})
+	p.s.resultLock.Unlock()
+
+	if p.failed > 0 {
+		return &PartialRestoreError{Failed: p.failed, Total: p.submitted}
+	}
+	return nil
+}
+
+func (s *S3svc) addRestored(restore VersionRestore) {
+	s.resultLock.Lock()
+	defer s.resultLock.Unlock()
+	s.Restored = append(s.Restored, restore)
+}
+
+func (s *S3svc) addFailure(failure Failure) {
+	s.resultLock.Lock()
+	defer s.resultLock.Unlock()
+	s.Failures = append(s.Failures, failure)
+}
+
+// recordFailure logs that action on version of key, size bytes long, failed
+// with err and adds it to Failures.
+func (s *S3svc) recordFailure(action, bucket, key, versionId string, size int64, err error) {
+	class := ClassifyError(err)
+	s.Log.Error(action+" failed", "action", action, "bucket", bucket, "key", key, "version_id", versionId,
+		"class", class, "error", err)
+	s.Progress.AddFailed(size)
+	s.addFailure(Failure{Key: key, VersionId: versionId, Class: class, Err: err})
+}
+
+// WriteFailures writes which versions couldn't be restored and why, with how
+// many failed of each class.
+func WriteFailures(w io.Writer, failures []Failure) {
+	if len(failures) == 0 {
+		return
+	}
+	counts := make(map[string]int)
+	fmt.Fprintf(w, "Failed:\n")
+	for _, failure := range failures {
+		fmt.Fprintf(w, " %-10s  %s?versionId=%s: %s\n", failure.Class, failure.Key, failure.VersionId, failure.Err)
+		counts[failure.Class]++
+	}
+	fmt.Fprintf(w, "%d retryable, %d permanent, %d permission errors\n",
+		counts[ErrorRetryable], counts[ErrorPermanent], counts[ErrorPermission])
+}
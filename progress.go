@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// ProgressCounts are what a restore has done so far.
+type ProgressCounts struct {
+	Listed       int64
+	Planned      int64
+	PlannedBytes int64
+	Copied       int64
+	CopiedBytes  int64
+	Skipped      int64
+	Failed       int64
+	FailedBytes  int64
+	ListingDone  bool
+}
+
+// Progress counts what a restore does as it goes. A nil Progress counts
+// nothing.
+type Progress struct {
+	lock  sync.Mutex
+	start time.Time
+	ProgressCounts
+}
+
+func NewProgress() *Progress {
+	return &Progress{start: time.Now()}
+}
+
+func (p *Progress) update(f func()) {
+	if p == nil {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	f()
+}
+
+func (p *Progress) AddListed()             { p.update(func() { p.Listed++ }) }
+func (p *Progress) AddSkipped()            { p.update(func() { p.Skipped++ }) }
+func (p *Progress) DoneListing()           { p.update(func() { p.ListingDone = true }) }
+func (p *Progress) AddPlanned(bytes int64) { p.update(func() { p.Planned++; p.PlannedBytes += bytes }) }
+func (p *Progress) AddCopied(bytes int64)  { p.update(func() { p.Copied++; p.CopiedBytes += bytes }) }
+func (p *Progress) AddFailed(bytes int64)  { p.update(func() { p.Failed++; p.FailedBytes += bytes }) }
+
+// Snapshot returns the counts so far, and how many bytes a second have been
+// copied since the start.
+func (p *Progress) Snapshot() (ProgressCounts, float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	throughput := 0.0
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		throughput = float64(p.CopiedBytes) / elapsed
+	}
+	return p.ProgressCounts, throughput
+}
+
+// ETA returns how long copying what's left of the plan should take at the
+// current throughput. It's only known once listing is done.
+func (p *Progress) ETA() (time.Duration, bool) {
+	snapshot, throughput := p.Snapshot()
+	if !snapshot.ListingDone {
+		return 0, false
+	}
+	remaining := snapshot.PlannedBytes - snapshot.CopiedBytes - snapshot.FailedBytes
+	if remaining <= 0 {
+		return 0, true
+	}
+	if throughput == 0 {
+		return 0, false
+	}
+	return time.Duration(float64(remaining) / throughput * float64(time.Second)).Round(time.Second), true
+}
+
+func humanBytes(n float64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	i := -1
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", n, units[i])
+}
+
+// Line formats the progress for a terminal.
+func (p *Progress) Line() string {
+	snapshot, throughput := p.Snapshot()
+	eta := "?"
+	if d, ok := p.ETA(); ok {
+		eta = d.String()
+	}
+	return fmt.Sprintf("%d listed, %d planned, %d copied, %d skipped, %d failed, %s of %s, %s/s, ETA %s",
+		snapshot.Listed, snapshot.Planned, snapshot.Copied, snapshot.Skipped, snapshot.Failed,
+		humanBytes(float64(snapshot.CopiedBytes)), humanBytes(float64(snapshot.PlannedBytes)), humanBytes(throughput), eta)
+}
+
+// Fields returns the progress as key/value pairs for a Logger. Throughput
+// is in bytes a second and the ETA in seconds.
+func (p *Progress) Fields() []interface{} {
+	snapshot, throughput := p.Snapshot()
+	var eta interface{} = "unknown"
+	if d, ok := p.ETA(); ok {
+		eta = int64(d.Seconds())
+	}
+	return []interface{}{
+		"listed", snapshot.Listed, "planned", snapshot.Planned, "copied", snapshot.Copied,
+		"skipped", snapshot.Skipped, "failed", snapshot.Failed,
+		"planned_bytes", snapshot.PlannedBytes, "copied_bytes", snapshot.CopiedBytes,
+		"throughput", int64(math.Round(throughput)), "eta", eta,
+	}
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ShowProgress writes p to log until the returned function is called, which
+// writes it one last time. On a terminal a single live line is rewritten
+// every second, with log lines written above it, otherwise a progress line is
+// logged every interval.
+func ShowProgress(p *Progress, log *Logger, terminal bool, interval time.Duration) (stop func()) {
+
+	show := func() {
+		log.ShowLive(p.Line())
+	}
+	if !terminal {
+		show = func() {
+			log.Info("progress", p.Fields()...)
+		}
+	} else {
+		interval = time.Second
+	}
+
+	done := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				show()
+			case <-done:
+				show()
+				if terminal {
+					log.ShowLive("")
+				}
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
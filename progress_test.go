@@ -0,0 +1,132 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Progress", func() {
+	var (
+		fake *fakeS3
+		svc  *S3svc
+	)
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("a", "a2", 200, true),
+				version("a", "a1", 100, false),
+				version("b", "b2", 200, true),
+				version("b", "b1", 100, false),
+				version("c", "c2", 200, true),
+				version("c", "c1", 100, false),
+				version("d", "d1", 100, true),
+			},
+		}
+		fake.Listing.Versions[3].Size = aws.Int64(0)
+		fake.Listing.Versions[4].Size = aws.Int64(20)
+		fake.Listing.Versions[5].Size = aws.Int64(30)
+		fake.CopyFailures["c1"] = []int{403}
+		svc = fake.S3svc()
+		svc.Filters = []VersionFilter{SizeFilter{MinSize: 1}}
+		svc.Progress = NewProgress()
+	})
+
+	It("Counts what a restore does", func() {
+		err := svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))
+		Expect(err).To(HaveOccurred())
+
+		counts, _ := svc.Progress.Snapshot()
+		Expect(counts).To(Equal(ProgressCounts{
+			Listed:       4,
+			Planned:      2,
+			PlannedBytes: 40,
+			Copied:       1,
+			CopiedBytes:  10,
+			Skipped:      1,
+			Failed:       1,
+			FailedBytes:  30,
+			ListingDone:  true,
+		}))
+	})
+
+	It("Estimates the time left once listing is done", func() {
+		progress := NewProgress()
+		progress.AddPlanned(100)
+		_, ok := progress.ETA()
+		Expect(ok).To(BeFalse())
+
+		progress.DoneListing()
+		time.Sleep(100 * time.Millisecond)
+		progress.AddCopied(50)
+		eta, ok := progress.ETA()
+		Expect(ok).To(BeTrue())
+		Expect(eta).To(BeNumerically("<=", time.Second))
+
+		progress.AddCopied(50)
+		eta, ok = progress.ETA()
+		Expect(ok).To(BeTrue())
+		Expect(eta).To(BeZero())
+	})
+
+	It("Logs progress periodically when not on a terminal", func() {
+		var out bytes.Buffer
+		log, _ := NewLogger(&out, LevelInfo, "text")
+		stop := ShowProgress(svc.Progress, log, false, 50*time.Millisecond)
+		svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))
+		time.Sleep(120 * time.Millisecond)
+		stop()
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		Expect(len(lines)).To(BeNumerically(">=", 3))
+		Expect(lines[len(lines)-1]).To(ContainSubstring("INFO progress listed=4 planned=2 copied=1 skipped=1 failed=1 planned_bytes=40 copied_bytes=10 throughput="))
+		Expect(lines[len(lines)-1]).To(HaveSuffix("eta=0"))
+	})
+
+	It("Logs progress as JSON with the rest of the log", func() {
+		var out bytes.Buffer
+		log, _ := NewLogger(&out, LevelInfo, "json")
+		svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))
+		ShowProgress(svc.Progress, log, false, time.Hour)()
+
+		var line map[string]interface{}
+		Expect(json.Unmarshal(out.Bytes(), &line)).To(Succeed())
+		Expect(line["msg"]).To(Equal("progress"))
+		Expect(line["copied"]).To(BeEquivalentTo(1))
+		Expect(line["eta"]).To(BeEquivalentTo(0))
+	})
+
+	It("Rewrites a single line on a terminal", func() {
+		var out bytes.Buffer
+		log, _ := NewLogger(&out, LevelInfo, "text")
+		stop := ShowProgress(svc.Progress, log, true, time.Hour)
+		svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))
+		stop()
+
+		Expect(out.String()).To(HavePrefix("\r4 listed, 2 planned, 1 copied, 1 skipped, 1 failed, 10 B of 40 B, "))
+		Expect(out.String()).To(HaveSuffix("\x1b[K\n"))
+	})
+
+	It("Logs above the line on a terminal", func() {
+		var out bytes.Buffer
+		log, _ := NewLogger(&out, LevelInfo, "text")
+		log.ShowLive("1 listed")
+		log.Info("restored", "key", "a")
+		log.ShowLive("2 listed")
+		log.ShowLive("")
+		log.Info("done")
+
+		Expect(out.String()).To(MatchRegexp(`^\r1 listed\x1b\[K\r\x1b\[K\S+ INFO restored key=a\n\r1 listed\x1b\[K\r2 listed\x1b\[K\n\S+ INFO done\n$`))
+	})
+})
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Report is what a restore did, for post-mortems and audits: how it was run,
+// what it changed and what went wrong.
+type Report struct {
+	Tool       string            `json:"tool"`
+	Version    string            `json:"version"`
+	Command    string            `json:"command"`
+	Parameters map[string]string `json:"parameters"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+
+	Counts ReportCounts `json:"counts"`
+	Bytes  ReportBytes  `json:"bytes"`
+
+	Changes    []ReportChange   `json:"changes"`
+	Errors     []ReportError    `json:"errors"`
+	Mismatches []ReportMismatch `json:"mismatches"`
+	Error      string           `json:"error,omitempty"`
+}
+
+type ReportCounts struct {
+	Listed     int64 `json:"listed"`
+	Planned    int64 `json:"planned"`
+	Restored   int64 `json:"restored"`
+	Skipped    int64 `json:"skipped"`
+	Failed     int64 `json:"failed"`
+	Mismatched int64 `json:"mismatched"`
+}
+
+type ReportBytes struct {
+	Planned  int64 `json:"planned"`
+	Restored int64 `json:"restored"`
+	Failed   int64 `json:"failed"`
+}
+
+// ReportChange is a key which was restored, from the version it was copied
+// from to the version the copy created. ReplacedVersionId is the version or
+// delete marker which was latest before, to restore to undo the change.
+type ReportChange struct {
+	Key               string `json:"key"`
+	VersionId         string `json:"version_id"`
+	NewVersionId      string `json:"new_version_id"`
+	ReplacedVersionId string `json:"replaced_version_id"`
+}
+
+type ReportError struct {
+	Key       string `json:"key"`
+	VersionId string `json:"version_id"`
+	Class     string `json:"class"`
+	Error     string `json:"error"`
+}
+
+type ReportMismatch struct {
+	Key          string `json:"key"`
+	VersionId    string `json:"version_id"`
+	NewVersionId string `json:"new_version_id"`
+	Reason       string `json:"reason"`
+}
+
+// NewReport starts a report of command, run with args to restore to
+// timestamp. The webhook URL is left out, as it may be a secret.
+func NewReport(command string, args map[string]string, timestamp time.Time) *Report {
+	parameters := make(map[string]string)
+	for name, value := range args {
+		if name != "notify-url" {
+			parameters[name] = value
+		}
+	}
+	return &Report{
+		Tool:       "s3r",
+		Version:    Version,
+		Command:    command,
+		Parameters: parameters,
+		Timestamp:  timestamp.UTC(),
+		Start:      time.Now().UTC(),
+		Changes:    []ReportChange{},
+		Errors:     []ReportError{},
+		Mismatches: []ReportMismatch{},
+	}
+}
+
+// Finish records what s did and err, what the restore returned.
+func (r *Report) Finish(s *S3svc, err error) {
+	r.End = time.Now().UTC()
+
+	r.Counts, r.Bytes = reportCounts(s.Progress)
+
+	s.resultLock.Lock()
+	defer s.resultLock.Unlock()
+	for _, restore := range s.Restored {
+		r.Changes = append(r.Changes, ReportChange{Key: restore.Key, VersionId: restore.VersionId, NewVersionId: restore.NewVersionId,
+			ReplacedVersionId: restore.ReplacedVersionId})
+	}
+	for _, failure := range s.Failures {
+		r.Errors = append(r.Errors, ReportError{Key: failure.Key, VersionId: failure.VersionId, Class: failure.Class, Error: failure.Err.Error()})
+	}
+	for _, mismatch := range s.Mismatches {
+		r.Mismatches = append(r.Mismatches, ReportMismatch{Key: mismatch.Key, VersionId: mismatch.VersionId, NewVersionId: mismatch.NewVersionId, Reason: mismatch.Reason})
+	}
+	r.Counts.Mismatched = int64(len(s.Mismatches))
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+// reportCounts returns what p has counted so far, or nothing if p is nil.
+func reportCounts(p *Progress) (ReportCounts, ReportBytes) {
+	if p == nil {
+		return ReportCounts{}, ReportBytes{}
+	}
+	counts, _ := p.Snapshot()
+	objects := ReportCounts{
+		Listed:   counts.Listed,
+		Planned:  counts.Planned,
+		Restored: counts.Copied,
+		Skipped:  counts.Skipped,
+		Failed:   counts.Failed,
+	}
+	bytes := ReportBytes{Planned: counts.PlannedBytes, Restored: counts.CopiedBytes, Failed: counts.FailedBytes}
+	return objects, bytes
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Tool}} {{.Command}} {{index .Parameters "bucket"}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; }
+</style>
+</head>
+<body>
+<h1>{{.Tool}} {{.Command}}</h1>
+{{if .Error}}<p><strong>Failed: {{.Error}}</strong></p>{{end}}
+<table>
+<tr><th>Version</th><td>{{.Version}}</td></tr>
+<tr><th>Restored to</th><td>{{.Timestamp}}</td></tr>
+<tr><th>Started</th><td>{{.Start}}</td></tr>
+<tr><th>Ended</th><td>{{.End}}</td></tr>
+</table>
+<h2>Parameters</h2>
+<table>
+{{range $name, $value := .Parameters}}<tr><th>{{$name}}</th><td>{{$value}}</td></tr>
+{{end}}</table>
+<h2>Counts</h2>
+<table>
+<tr><th>Listed</th><td>{{.Counts.Listed}}</td></tr>
+<tr><th>Planned</th><td>{{.Counts.Planned}}</td><td>{{.Bytes.Planned}} bytes</td></tr>
+<tr><th>Restored</th><td>{{.Counts.Restored}}</td><td>{{.Bytes.Restored}} bytes</td></tr>
+<tr><th>Skipped</th><td>{{.Counts.Skipped}}</td></tr>
+<tr><th>Failed</th><td>{{.Counts.Failed}}</td><td>{{.Bytes.Failed}} bytes</td></tr>
+<tr><th>Mismatched</th><td>{{.Counts.Mismatched}}</td></tr>
+</table>
+<h2>Changes</h2>
+<table>
+<tr><th>Key</th><th>Replaced version</th><th>Restored version</th><th>New version</th></tr>
+{{range .Changes}}<tr><td>{{.Key}}</td><td>{{.ReplacedVersionId}}</td><td>{{.VersionId}}</td><td>{{.NewVersionId}}</td></tr>
+{{end}}</table>
+{{if .Errors}}<h2>Errors</h2>
+<table>
+<tr><th>Key</th><th>Version</th><th>Class</th><th>Error</th></tr>
+{{range .Errors}}<tr><td>{{.Key}}</td><td>{{.VersionId}}</td><td>{{.Class}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Mismatches}}<h2>Mismatches</h2>
+<table>
+<tr><th>Key</th><th>Version</th><th>New version</th><th>Reason</th></tr>
+{{range .Mismatches}}<tr><td>{{.Key}}</td><td>{{.VersionId}}</td><td>{{.NewVersionId}}</td><td>{{.Reason}}</td></tr>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
+
+// WriteHTML writes the report as an HTML page.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, r)
+}
+
+// WriteFile writes the report to path, as HTML if it ends in .html or .htm,
+// otherwise as JSON.
+func (r *Report) WriteFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	write := r.WriteJSON
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".html" || ext == ".htm" {
+		write = r.WriteHTML
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
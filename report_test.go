@@ -0,0 +1,91 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Report", func() {
+	var (
+		svc    *S3svc
+		report *Report
+	)
+
+	BeforeEach(func() {
+		fake := newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("a", "a2", 200, true),
+				version("a", "a1", 100, false),
+				version("b", "b2", 200, true),
+				version("b", "b1", 100, false),
+				version("c", "c1", 100, true),
+			},
+		}
+		fake.CopyFailures["b1"] = []int{403}
+		svc = fake.S3svc()
+		svc.Progress = NewProgress()
+
+		report = NewReport("restore", map[string]string{"bucket": "mybucket", "timestamp": "150", "notify-url": "https://hooks.example.com/secret"}, time.Unix(150, 0))
+		err := svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))
+		Expect(err).To(HaveOccurred())
+		report.Finish(svc, err)
+	})
+
+	It("Records what was changed and what failed", func() {
+		var out bytes.Buffer
+		Expect(report.WriteJSON(&out)).To(Succeed())
+
+		var decoded Report
+		Expect(json.Unmarshal(out.Bytes(), &decoded)).To(Succeed())
+		Expect(decoded.Command).To(Equal("restore"))
+		Expect(decoded.Parameters).To(HaveKeyWithValue("bucket", "mybucket"))
+		Expect(decoded.Parameters).ToNot(HaveKey("notify-url"))
+		Expect(decoded.Timestamp.Equal(time.Unix(150, 0))).To(BeTrue())
+		Expect(decoded.End).ToNot(BeTemporally("<", decoded.Start))
+		Expect(decoded.Counts).To(Equal(ReportCounts{Listed: 3, Planned: 2, Restored: 1, Failed: 1}))
+		Expect(decoded.Changes).To(HaveLen(1))
+		Expect(decoded.Changes[0].Key).To(Equal("a"))
+		Expect(decoded.Changes[0].VersionId).To(Equal("a1"))
+		Expect(decoded.Changes[0].NewVersionId).ToNot(BeEmpty())
+		Expect(decoded.Changes[0].ReplacedVersionId).To(Equal("a2"))
+		Expect(decoded.Errors).To(HaveLen(1))
+		Expect(decoded.Errors[0].Key).To(Equal("b"))
+		Expect(decoded.Errors[0].Class).To(Equal(ErrorPermission))
+		Expect(decoded.Error).To(Equal("1 of 2 objects couldn't be restored"))
+
+		Expect(out.String()).To(ContainSubstring(`"new_version_id"`))
+		Expect(out.String()).To(ContainSubstring(`"replaced_version_id": "a2"`))
+	})
+
+	It("Writes HTML when the file is named .html", func() {
+		dir, err := ioutil.TempDir("", "report")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		path := filepath.Join(dir, "report.html")
+		Expect(report.WriteFile(path)).To(Succeed())
+		html, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(html)).To(HavePrefix("<!DOCTYPE html>"))
+		Expect(string(html)).To(ContainSubstring("<td>a</td><td>a2</td><td>a1</td>"))
+		Expect(string(html)).To(ContainSubstring("Failed: 1 of 2 objects couldn&#39;t be restored"))
+
+		path = filepath.Join(dir, "report.json")
+		Expect(report.WriteFile(path)).To(Succeed())
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(json.Valid(contents)).To(BeTrue())
+	})
+})
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// VersionRestore asks for VersionId of Key to become its latest version.
+// NewVersionId is the version created by the restore, and ReplacedVersionId,
+// if known, the version or delete marker which was latest before it.
+type VersionRestore struct {
+	Key               string
+	VersionId         string
+	NewVersionId      string
+	ReplacedVersionId string
+}
+
+// ReadVersionRestores reads key,versionId pairs from CSV. A header row naming
+// the columns is allowed.
+func ReadVersionRestores(r io.Reader) ([]VersionRestore, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var restores []VersionRestore
+	for i, record := range records {
+		if i == 0 && strings.EqualFold(record[0], "key") && strings.EqualFold(record[1], "versionId") {
+			continue
+		}
+		if record[0] == "" || record[1] == "" {
+			return nil, fmt.Errorf("line %d: key and version ID are required", i+1)
+		}
+		restores = append(restores, VersionRestore{Key: record[0], VersionId: record[1]})
+	}
+	return restores, nil
+}
+
+// RestoreVersions copies the requested versions over their keys, leaving
+// protected keys alone. Every version is checked to exist under its key, and
+// the restore against the limits and confirmed, before anything is copied, so
+// a mistake in a bulk request doesn't leave the bucket half restored. Versions
+// which can't be copied are added to Failures rather than stopping the others,
+// and a PartialRestoreError is returned. Once ctx is done no more versions are
+// copied and a CanceledError is returned.
+func (s *S3svc) RestoreVersions(ctx context.Context, bucket string, restores []VersionRestore) ([]VersionRestore, error) {
+
+	if err := s.CheckVersioning(ctx, bucket); err != nil {
+		return nil, err
+	}
+	var plan []VersionRestore
+	var sizes []int64
+	summary := PlanSummary{Command: "restore-version", Bucket: bucket}
+	for _, restore := range restores {
+		if s.protectedKey(bucket, restore.Key, restore.VersionId) {
+			continue
+		}
+		head, err := s.HeadVersion(ctx, bucket, restore.Key, restore.VersionId)
+		if _, ok := err.(*deleteMarkerError); ok {
+			return nil, fmt.Errorf("version %s of %s: is a delete marker", restore.VersionId, restore.Key)
+		}
+		if err != nil {
+			described := fmt.Errorf("version %s of %s: %s", restore.VersionId, restore.Key, err)
+			if ClassifyError(err) == ErrorPermission {
+				return nil, &PermissionError{Err: described}
+			}
+			return nil, described
+		}
+		if head.VersionId != nil && *head.VersionId != restore.VersionId {
+			return nil, fmt.Errorf("version %s of %s: found version %s instead", restore.VersionId, restore.Key, *head.VersionId)
+		}
+		plan = append(plan, restore)
+		sizes = append(sizes, aws.Int64Value(head.ContentLength))
+		summary.Bytes += aws.Int64Value(head.ContentLength)
+	}
+	summary.Objects = len(plan)
+	if err := s.checkLimits(summary.Objects, summary.Bytes); err != nil {
+		return nil, err
+	}
+	if err := s.confirm(summary); err != nil {
+		return nil, err
+	}
+
+	mismatches := s.mismatchCount()
+	var restored []VersionRestore
+	for i, restore := range plan {
+		if err := canceled(ctx); err != nil {
+			return restored, err
+		}
+		copyResp, err := s.copyVersion(ctx, bucket, restore.Key, restore.VersionId, sizes[i])
+		if err != nil {
+			s.recordFailure("restore", bucket, restore.Key, restore.VersionId, sizes[i], err)
+			continue
+		}
+
+		if copyResp.VersionId != nil {
+			restore.NewVersionId = *copyResp.VersionId
+		}
+		restored = append(restored, restore)
+	}
+	if len(restored) < len(plan) {
+		return restored, &PartialRestoreError{Failed: len(plan) - len(restored), Total: len(plan)}
+	}
+	return restored, s.verificationError(mismatches)
+}
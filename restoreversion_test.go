@@ -0,0 +1,91 @@
+package main_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Version restore", func() {
+
+	It("Reads key,versionId pairs with an optional header", func() {
+		restores, err := ReadVersionRestores(strings.NewReader("key,versionId\na, v1\nb/c,v2\n"))
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(restores).To(Equal([]VersionRestore{
+			{Key: "a", VersionId: "v1"},
+			{Key: "b/c", VersionId: "v2"},
+		}))
+	})
+
+	It("Rejects malformed CSV", func() {
+		_, err := ReadVersionRestores(strings.NewReader("a,v1\nb\n"))
+		Expect(err).To(HaveOccurred())
+
+		_, err = ReadVersionRestores(strings.NewReader("a,\n"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Restores versions and reports the new version IDs", func() {
+		fake := newFakeS3()
+		fake.Heads["v1"] = http.Header{}
+		fake.Heads["v2"] = http.Header{}
+
+		restored, err := fake.S3svc().RestoreVersions(context.Background(), "mybucket", []VersionRestore{
+			{Key: "a", VersionId: "v1"},
+			{Key: "b", VersionId: "v2"},
+		})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fake.Copied).To(Equal([]string{"v1", "v2"}))
+		Expect(restored).To(Equal([]VersionRestore{
+			{Key: "a", VersionId: "v1", NewVersionId: "copy-of-v1"},
+			{Key: "b", VersionId: "v2", NewVersionId: "copy-of-v2"},
+		}))
+	})
+
+	It("Restores the other versions when one fails, then reports a partial restore", func() {
+		fake := newFakeS3()
+		fake.Heads["v1"] = http.Header{}
+		fake.Heads["v2"] = http.Header{}
+		fake.CopyFailures["v1"] = []int{403}
+		svc := fake.S3svc()
+
+		restored, err := svc.RestoreVersions(context.Background(), "mybucket", []VersionRestore{
+			{Key: "a", VersionId: "v1"},
+			{Key: "b", VersionId: "v2"},
+		})
+
+		Expect(err).To(BeAssignableToTypeOf(&PartialRestoreError{}))
+		Expect(restored).To(Equal([]VersionRestore{{Key: "b", VersionId: "v2", NewVersionId: "copy-of-v2"}}))
+		Expect(svc.Failures).To(HaveLen(1))
+	})
+
+	It("Doesn't copy anything when a version doesn't exist", func() {
+		fake := newFakeS3()
+		fake.Heads["v1"] = http.Header{}
+
+		_, err := fake.S3svc().RestoreVersions(context.Background(), "mybucket", []VersionRestore{
+			{Key: "a", VersionId: "v1"},
+			{Key: "b", VersionId: "missing"},
+		})
+
+		Expect(err).To(MatchError(ContainSubstring("version missing of b")))
+		Expect(fake.Copied).To(BeEmpty())
+	})
+
+	It("Refuses to restore a delete marker", func() {
+		fake := newFakeS3()
+		fake.Heads["d1"] = http.Header{"X-Amz-Delete-Marker": []string{"true"}}
+
+		_, err := fake.S3svc().RestoreVersions(context.Background(), "mybucket", []VersionRestore{{Key: "a", VersionId: "d1"}})
+
+		Expect(err).To(MatchError("version d1 of a: is a delete marker"))
+		Expect(fake.Copied).To(BeEmpty())
+	})
+
+})
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Classes of errors, see ClassifyError.
+const (
+	ErrorRetryable  = "retryable"
+	ErrorPermanent  = "permanent"
+	ErrorPermission = "permission"
+)
+
+// ClassifyError says whether a failed request is worth trying again, was
+// refused for lack of permission, or will fail however often it's tried.
+func ClassifyError(err error) string {
+
+	if _, ok := err.(*PermissionError); ok {
+		return ErrorPermission
+	}
+
+	reqErr, ok := err.(awserr.RequestFailure)
+	if !ok {
+		// Requests which couldn't be sent at all, e.g. on network errors.
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == "RequestError" {
+			return ErrorRetryable
+		}
+		return ErrorPermanent
+	}
+
+	switch reqErr.Code() {
+	case "AccessDenied", "AllAccessDisabled", "AccountProblem", "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken", "InvalidToken":
+		return ErrorPermission
+	case "SlowDown", "ServiceUnavailable", "InternalError", "RequestTimeout", "Throttling", "ThrottlingException", "RequestThrottled":
+		return ErrorRetryable
+	}
+	// Responses to HEAD requests have no body to take a code from.
+	switch status := reqErr.StatusCode(); {
+	case status == 401 || status == 403:
+		return ErrorPermission
+	case status == 429 || status >= 500:
+		return ErrorRetryable
+	}
+	return ErrorPermanent
+}
+
+func isSlowDown(err error) bool {
+	reqErr, ok := err.(awserr.RequestFailure)
+	return ok && (reqErr.Code() == "SlowDown" || reqErr.StatusCode() == 503)
+}
+
+// Retryer retries requests failing with retryable errors, waiting a random
+// time of up to BaseDelay, doubling with each attempt up to MaxDelay, in
+// between. SlowDown responses also tell the Limiter to run fewer copies at
+// once.
+type Retryer struct {
+	NumMaxRetries int
+	BaseDelay     time.Duration
+	MaxDelay      time.Duration
+	Limiter       *Limiter
+}
+
+// NewRetryer returns a Retryer suited to restoring large buckets, which keeps
+// trying for a few minutes.
+func NewRetryer(limiter *Limiter) *Retryer {
+	return &Retryer{
+		NumMaxRetries: 10,
+		BaseDelay:     100 * time.Millisecond,
+		MaxDelay:      30 * time.Second,
+		Limiter:       limiter,
+	}
+}
+
+func (r *Retryer) MaxRetries() int {
+	return r.NumMaxRetries
+}
+
+func (r *Retryer) RetryRules(req *request.Request) time.Duration {
+	delay := r.MaxDelay
+	if req.RetryCount < 32 {
+		if d := r.BaseDelay << uint(req.RetryCount); d > 0 && d < delay {
+			delay = d
+		}
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+func (r *Retryer) ShouldRetry(req *request.Request) bool {
+	// Requests abandoned by their context are not tried again.
+	if req.HTTPRequest != nil && req.HTTPRequest.Context().Err() != nil {
+		return false
+	}
+	if r.Limiter != nil && isSlowDown(req.Error) {
+		r.Limiter.SlowDown()
+	}
+	return ClassifyError(req.Error) == ErrorRetryable
+}
+
+// Limiter bounds how many copies run at once. The bound starts at Max, halves
+// whenever S3 asks to slow down, and grows back by one after as many
+// successful copies in a row as the bound.
+type Limiter struct {
+	lock      sync.Mutex
+	cond      *sync.Cond
+	max       int
+	limit     int
+	active    int
+	successes int
+}
+
+func NewLimiter(max int) *Limiter {
+	if max < 1 {
+		max = 1
+	}
+	l := &Limiter{max: max, limit: max}
+	l.cond = sync.NewCond(&l.lock)
+	return l
+}
+
+// Max returns the most copies ever run at once.
+func (l *Limiter) Max() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.max
+}
+
+// SetMax changes the most copies run at once, and the current bound with it.
+func (l *Limiter) SetMax(max int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if max < 1 {
+		max = 1
+	}
+	l.max, l.limit = max, max
+	l.cond.Broadcast()
+}
+
+// Limit returns how many copies may currently run at once.
+func (l *Limiter) Limit() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.limit
+}
+
+// Acquire waits until another copy may start.
+func (l *Limiter) Acquire() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for l.active >= l.limit {
+		l.cond.Wait()
+	}
+	l.active++
+}
+
+// Release ends a copy started by Acquire.
+func (l *Limiter) Release(succeeded bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.active--
+	if succeeded {
+		l.successes++
+		if l.successes >= l.limit && l.limit < l.max {
+			l.limit++
+			l.successes = 0
+		}
+	}
+	l.cond.Broadcast()
+}
+
+// SlowDown halves the number of copies run at once.
+func (l *Limiter) SlowDown() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.limit /= 2; l.limit < 1 {
+		l.limit = 1
+	}
+	l.successes = 0
+}
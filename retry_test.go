@@ -0,0 +1,149 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Retries", func() {
+
+	It("Classifies errors", func() {
+		failure := func(code string, status int) error {
+			return awserr.NewRequestFailure(awserr.New(code, "failed", nil), status, "")
+		}
+
+		Expect(ClassifyError(failure("SlowDown", 503))).To(Equal(ErrorRetryable))
+		Expect(ClassifyError(failure("InternalError", 500))).To(Equal(ErrorRetryable))
+		Expect(ClassifyError(awserr.New("RequestError", "send request failed", nil))).To(Equal(ErrorRetryable))
+		Expect(ClassifyError(failure("AccessDenied", 403))).To(Equal(ErrorPermission))
+		Expect(ClassifyError(failure("Forbidden", 403))).To(Equal(ErrorPermission))
+		Expect(ClassifyError(failure("NoSuchKey", 404))).To(Equal(ErrorPermanent))
+		Expect(ClassifyError(errors.New("failed"))).To(Equal(ErrorPermanent))
+	})
+
+	It("Backs off exponentially, with jitter", func() {
+		retryer := &Retryer{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+		for i := 0; i < 20; i++ {
+			Expect(retryer.RetryRules(&request.Request{RetryCount: 0})).To(BeNumerically("<=", 100*time.Millisecond))
+			Expect(retryer.RetryRules(&request.Request{RetryCount: 2})).To(BeNumerically("<=", 400*time.Millisecond))
+			Expect(retryer.RetryRules(&request.Request{RetryCount: 40})).To(BeNumerically("<=", time.Second))
+		}
+	})
+
+	Describe("Limiter", func() {
+
+		It("Halves on SlowDown and grows back with successes", func() {
+			limiter := NewLimiter(8)
+
+			limiter.SlowDown()
+			limiter.SlowDown()
+			Expect(limiter.Limit()).To(Equal(2))
+
+			limiter.Acquire()
+			limiter.Release(true)
+			Expect(limiter.Limit()).To(Equal(2))
+			limiter.Acquire()
+			limiter.Release(true)
+			Expect(limiter.Limit()).To(Equal(3))
+
+			limiter.SetMax(1)
+			limiter.SlowDown()
+			Expect(limiter.Limit()).To(Equal(1))
+			limiter.Acquire()
+			limiter.Release(true)
+			Expect(limiter.Limit()).To(Equal(1))
+		})
+
+		It("Waits while the limit is reached", func() {
+			limiter := NewLimiter(2)
+			limiter.Acquire()
+			limiter.Acquire()
+
+			acquired := make(chan bool)
+			go func() {
+				limiter.Acquire()
+				close(acquired)
+			}()
+			Consistently(acquired).ShouldNot(BeClosed())
+
+			limiter.Release(false)
+			Eventually(acquired).Should(BeClosed())
+		})
+	})
+
+	Describe("Restoring", func() {
+		var (
+			fake *fakeS3
+			svc  *S3svc
+		)
+
+		BeforeEach(func() {
+			fake = newFakeS3()
+			fake.Listing = &s3.ListObjectVersionsOutput{
+				Versions: []*s3.ObjectVersion{
+					version("a", "a2", 200, true),
+					version("a", "a1", 100, false),
+					version("b", "b2", 200, true),
+					version("b", "b1", 100, false),
+				},
+			}
+			svc = fake.S3svc()
+			svc.Limiter.SetMax(4)
+		})
+
+		It("Retries copies S3 asks to slow down, copying fewer at once", func() {
+			fake.CopyFailures["a1"] = []int{503, 503}
+
+			err := svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))
+
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fake.Calls["CopyObject"]).To(Equal(4))
+			Expect(fake.Copied).To(ConsistOf("a1", "b1"))
+			Expect(svc.Limiter.Limit()).To(BeNumerically("<", 4))
+			Expect(svc.Failures).To(BeEmpty())
+		})
+
+		It("Records copies which keep failing, and restores the rest", func() {
+			fake.CopyFailures["a1"] = []int{500, 500, 500, 500}
+
+			err := svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))
+
+			Expect(err).To(MatchError("1 of 2 objects couldn't be restored"))
+			Expect(fake.Calls["CopyObject"]).To(Equal(5))
+			Expect(fake.Copied).To(Equal([]string{"b1"}))
+			Expect(svc.Failures).To(HaveLen(1))
+			Expect(svc.Failures[0].Key).To(Equal("a"))
+			Expect(svc.Failures[0].VersionId).To(Equal("a1"))
+			Expect(svc.Failures[0].Class).To(Equal(ErrorRetryable))
+		})
+
+		It("Doesn't retry errors which won't go away", func() {
+			fake.CopyFailures["a1"] = []int{403}
+			fake.CopyFailures["b1"] = []int{400}
+
+			err := svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))
+
+			Expect(err).To(MatchError("2 of 2 objects couldn't be restored"))
+			Expect(fake.Calls["CopyObject"]).To(Equal(2))
+			Expect(svc.Failures).To(HaveLen(2))
+			Expect(svc.Failures[0].Class).To(Equal(ErrorPermission))
+			Expect(svc.Failures[1].Class).To(Equal(ErrorPermanent))
+
+			var out bytes.Buffer
+			WriteFailures(&out, svc.Failures)
+			Expect(out.String()).To(HavePrefix("Failed:\n permission  a?versionId=a1: AccessDenied: failed"))
+			Expect(out.String()).To(HaveSuffix("0 retryable, 1 permanent, 1 permission errors\n"))
+		})
+	})
+})
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// keyChange is a version or a delete marker of a key.
+type keyChange struct {
+	VersionId    string
+	LastModified time.Time
+	DeleteMarker bool
+	Size         int64
+}
+
+// changesByKey merges versions and delete markers into per-key histories,
+// most recent change first.
+func changesByKey(versions *s3.ListObjectVersionsOutput) (keys []string, changes map[string][]keyChange) {
+	changes = make(map[string][]keyChange)
+	add := func(key, versionId string, lastModified time.Time, deleteMarker bool, size int64) {
+		if _, ok := changes[key]; !ok {
+			keys = append(keys, key)
+		}
+		changes[key] = append(changes[key], keyChange{
+			VersionId:    versionId,
+			LastModified: lastModified,
+			DeleteMarker: deleteMarker,
+			Size:         size,
+		})
+	}
+
+	for _, version := range versions.Versions {
+		add(*version.Key, *version.VersionId, *version.LastModified, false, aws.Int64Value(version.Size))
+	}
+	for _, marker := range versions.DeleteMarkers {
+		add(*marker.Key, *marker.VersionId, *marker.LastModified, true, 0)
+	}
+
+	for _, key := range keys {
+		history := changes[key]
+		sort.SliceStable(history, func(i, j int) bool {
+			return history[i].LastModified.After(history[j].LastModified)
+		})
+	}
+	sort.Strings(keys)
+	return keys, changes
+}
+
+// rollbackChange reverts Key to Previous, or deletes it if Previous is nil.
+type rollbackChange struct {
+	Key      string
+	Previous *keyChange
+}
+
+// planRollback returns the changes RollbackObjects makes and the keys it
+// leaves alone as conflicts. Protected keys are left alone too.
+func (s *S3svc) planRollback(bucket string, versions *s3.ListObjectVersionsOutput, from, to time.Time) (plan []rollbackChange, conflicts []string) {
+
+	keys, changes := changesByKey(versions)
+	for _, key := range keys {
+		history := changes[key]
+		latest := history[0]
+
+		if latest.LastModified.After(to) {
+			for _, change := range history {
+				if !change.LastModified.Before(from) && !change.LastModified.After(to) {
+					conflicts = append(conflicts, key)
+					break
+				}
+			}
+			continue
+		}
+		if latest.LastModified.Before(from) {
+			continue
+		}
+
+		var previous *keyChange
+		for i := range history {
+			if history[i].LastModified.Before(from) {
+				previous = &history[i]
+				break
+			}
+		}
+
+		// A key which didn't exist before the window is deleted again. The
+		// delete marker keeps the versions written in the window recoverable.
+		if previous == nil || previous.DeleteMarker {
+			if latest.DeleteMarker || s.protectedKey(bucket, key, latest.VersionId) {
+				continue
+			}
+			plan = append(plan, rollbackChange{Key: key})
+			continue
+		}
+		if !s.protectedKey(bucket, key, previous.VersionId) {
+			plan = append(plan, rollbackChange{Key: key, Previous: previous})
+		}
+	}
+	return plan, conflicts
+}
+
+// RollbackObjects reverts the keys whose latest change falls within
+// [from, to] to their state before from. Keys which were also changed after
+// to are not touched and are returned as conflicts, as reverting them would
+// lose legitimate writes. The rollback is checked against the limits and
+// confirmed before anything is changed. Keys which can't be reverted are
+// added to Failures rather than stopping the others, and a
+// PartialRestoreError is returned. Once ctx is done no more keys are
+// reverted and a CanceledError is returned.
+func (s *S3svc) RollbackObjects(ctx context.Context, bucket string, versions *s3.ListObjectVersionsOutput, from, to time.Time) ([]string, error) {
+
+	if err := s.CheckVersioning(ctx, bucket); err != nil {
+		return nil, err
+	}
+	plan, conflicts := s.planRollback(bucket, versions, from, to)
+	summary := PlanSummary{Command: "rollback", Bucket: bucket, Prefix: aws.StringValue(versions.Prefix), From: from, Timestamp: to, Objects: len(plan)}
+	for _, change := range plan {
+		if change.Previous == nil {
+			summary.Deletes++
+		} else {
+			summary.Bytes += change.Previous.Size
+		}
+	}
+	if err := s.checkLimits(summary.Objects, summary.Bytes); err != nil {
+		return nil, err
+	}
+	if err := s.confirm(summary); err != nil {
+		return nil, err
+	}
+
+	mismatches := s.mismatchCount()
+	failed := 0
+	for _, change := range plan {
+		if err := canceled(ctx); err != nil {
+			return conflicts, err
+		}
+		if change.Previous != nil {
+			if _, err := s.copyVersion(ctx, bucket, change.Key, change.Previous.VersionId, change.Previous.Size); err != nil {
+				s.recordFailure("restore", bucket, change.Key, change.Previous.VersionId, change.Previous.Size, err)
+				failed++
+			}
+			continue
+		}
+
+		s.Log.Debug("deleting", "action", "delete", "bucket", bucket, "key", change.Key)
+		start := time.Now()
+		deleteResp, err := s.DeleteObject(ctx, bucket, change.Key)
+		if err != nil {
+			s.recordFailure("delete", bucket, change.Key, "", 0, err)
+			failed++
+			continue
+		}
+		s.Log.Info("deleted", "action", "delete", "bucket", bucket, "key", change.Key,
+			"version_id", aws.StringValue(deleteResp.VersionId), "duration", time.Since(start))
+	}
+	if failed > 0 {
+		return conflicts, &PartialRestoreError{Failed: failed, Total: len(plan)}
+	}
+	return conflicts, s.verificationError(mismatches)
+}
@@ -0,0 +1,125 @@
+package main_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func version(key, id string, lastModified int64, latest bool) *s3.ObjectVersion {
+	return &s3.ObjectVersion{
+		Key:          aws.String(key),
+		IsLatest:     aws.Bool(latest),
+		LastModified: aws.Time(time.Unix(lastModified, 0)),
+		VersionId:    aws.String(id),
+		Size:         aws.Int64(10),
+	}
+}
+
+func deleteMarker(key, id string, lastModified int64, latest bool) *s3.DeleteMarkerEntry {
+	return &s3.DeleteMarkerEntry{
+		Key:          aws.String(key),
+		IsLatest:     aws.Bool(latest),
+		LastModified: aws.Time(time.Unix(lastModified, 0)),
+		VersionId:    aws.String(id),
+	}
+}
+
+var _ = Describe("Rollback", func() {
+	var (
+		fake     *fakeS3
+		from, to time.Time
+	)
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+		from, to = time.Unix(200, 0), time.Unix(300, 0)
+	})
+
+	rollback := func(versions *s3.ListObjectVersionsOutput) []string {
+		conflicts, err := fake.S3svc().RollbackObjects(context.Background(), "mybucket", versions, from, to)
+		Expect(err).ToNot(HaveOccurred())
+		return conflicts
+	}
+
+	It("Restores the version from before the window", func() {
+		conflicts := rollback(&s3.ListObjectVersionsOutput{Versions: []*s3.ObjectVersion{
+			version("a", "a3", 250, true),
+			version("a", "a2", 210, false),
+			version("a", "a1", 100, false),
+		}})
+
+		Expect(conflicts).To(BeEmpty())
+		Expect(fake.Copied).To(Equal([]string{"a1"}))
+	})
+
+	It("Leaves keys changed only outside the window alone", func() {
+		conflicts := rollback(&s3.ListObjectVersionsOutput{Versions: []*s3.ObjectVersion{
+			version("a", "a2", 100, true),
+			version("a", "a1", 50, false),
+			version("b", "b2", 400, true),
+			version("b", "b1", 100, false),
+		}})
+
+		Expect(conflicts).To(BeEmpty())
+		Expect(fake.Copied).To(BeEmpty())
+		Expect(fake.Deleted).To(BeEmpty())
+	})
+
+	It("Reports keys changed inside and after the window as conflicts", func() {
+		conflicts := rollback(&s3.ListObjectVersionsOutput{Versions: []*s3.ObjectVersion{
+			version("a", "a3", 400, true),
+			version("a", "a2", 250, false),
+			version("a", "a1", 100, false),
+		}})
+
+		Expect(conflicts).To(Equal([]string{"a"}))
+		Expect(fake.Copied).To(BeEmpty())
+	})
+
+	It("Deletes keys created within the window", func() {
+		conflicts := rollback(&s3.ListObjectVersionsOutput{Versions: []*s3.ObjectVersion{
+			version("new", "n1", 250, true),
+		}})
+
+		Expect(conflicts).To(BeEmpty())
+		Expect(fake.Deleted).To(Equal([]string{"new"}))
+	})
+
+	It("Restores keys deleted within the window", func() {
+		conflicts := rollback(&s3.ListObjectVersionsOutput{
+			Versions:      []*s3.ObjectVersion{version("a", "a1", 100, false)},
+			DeleteMarkers: []*s3.DeleteMarkerEntry{deleteMarker("a", "d1", 250, true)},
+		})
+
+		Expect(conflicts).To(BeEmpty())
+		Expect(fake.Copied).To(Equal([]string{"a1"}))
+	})
+
+	It("Reverts the other keys when one fails, then reports a partial rollback", func() {
+		fake.CopyFailures["a1"] = []int{403}
+		svc := fake.S3svc()
+
+		_, err := svc.RollbackObjects(context.Background(), "mybucket", &s3.ListObjectVersionsOutput{Versions: []*s3.ObjectVersion{
+			version("a", "a2", 250, true),
+			version("a", "a1", 100, false),
+			version("b", "b2", 250, true),
+			version("b", "b1", 100, false),
+			version("new", "n1", 250, true),
+		}}, from, to)
+
+		Expect(err).To(MatchError("1 of 3 objects couldn't be restored"))
+		Expect(ExitCode(err)).To(Equal(ExitPartial))
+		Expect(fake.Copied).To(Equal([]string{"b1"}))
+		Expect(fake.Deleted).To(Equal([]string{"new"}))
+		Expect(svc.Failures).To(HaveLen(1))
+		Expect(svc.Failures[0].Class).To(Equal(ErrorPermission))
+	})
+
+})
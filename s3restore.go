@@ -1,14 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"log"
+	"net/http"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
@@ -22,81 +25,498 @@ type ParsedArgs struct {
 	Args        map[string]string
 }
 
+// logger is used by main, until the command line says how to log, and by
+// parseArguments.
+var logger, _ = NewLogger(os.Stderr, LevelInfo, "text")
+
 type S3svc struct {
-	Svc *s3.S3
+	Svc       *s3.S3
+	Filters   []VersionFilter
+	IndexDir  string
+	Inventory string
+	// MaxIndexVersions, if set, is the most versions an index or inventory
+	// report may have.
+	MaxIndexVersions int
+	ListParallelism  int
+
+	Verify     bool
+	Mismatches []Mismatch
+
+	// Limiter bounds how many copies run at once, RequestRate and ByteRate
+	// how fast they run. Restored are the copies which succeeded and
+	// Failures those which failed.
+	Limiter     *Limiter
+	RequestRate *PrefixRates
+	ByteRate    *PrefixRates
+	Restored    []VersionRestore
+	Failures    []Failure
+
+	// Progress, if set, counts what a restore has done so far, and Metrics
+	// what it has done for Prometheus.
+	Progress *Progress
+	Metrics  *Metrics
+
+	// Keys under Protected are never restored. A restore planning to copy
+	// more than MaxChanges objects or MaxBytes bytes is aborted, as is one
+	// Confirm, if set, doesn't go ahead with. Zero is no limit.
+	Protected  []string
+	MaxChanges int
+	MaxBytes   int64
+	Confirm    func(PlanSummary) (bool, error)
+
+	Log *Logger
+
+	resultLock sync.Mutex
+	cacheLock  sync.Mutex
+	heads      map[string]*s3.HeadObjectOutput
+	tags       map[string][]*s3.Tag
 }
 
-func NewS3svc() *S3svc {
+func NewS3svc() (*S3svc, error) {
 
 	sess, err := session.NewSession()
 	if err != nil {
-		log.Fatal("failed to create session,", err)
+		return nil, err
 	}
 
+	limiter := NewLimiter(1)
 	return &S3svc{
-		Svc: s3.New(sess),
+		Svc:     s3.New(sess, request.WithRetryer(aws.NewConfig(), NewRetryer(limiter))),
+		Limiter: limiter,
+	}, nil
+}
+
+// ListVersions returns every version and delete marker under prefix. If
+// Inventory is set they come from that S3 Inventory report, topped up with
+// changes made since. Otherwise if IndexDir is set they come from the local
+// index of the bucket, which is refreshed first. Listing stops once ctx is
+// done.
+func (s *S3svc) ListVersions(ctx context.Context, bucket, prefix string) (*s3.ListObjectVersionsOutput, error) {
+
+	var idx *VersionIndex
+	var err error
+	switch {
+	case s.Inventory != "":
+		idx, err = s.LoadInventory(s.Inventory, bucket, prefix)
+	case s.IndexDir != "":
+		idx, err = LoadIndex(IndexPath(s.IndexDir, bucket), bucket)
+	default:
+		return s.listAllVersions(ctx, bucket, prefix)
 	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkIndexSize(bucket, idx.Size()); err != nil {
+		return nil, err
+	}
+
+	if err := s.RefreshIndex(ctx, idx, prefix); err != nil {
+		return nil, err
+	}
+	// Refusing to save an index over the limit keeps every index file small
+	// enough to load.
+	if err := s.checkIndexSize(bucket, idx.Size()); err != nil {
+		return nil, err
+	}
+	if s.Inventory == "" {
+		if err := idx.Save(); err != nil {
+			return nil, err
+		}
+	}
+	return idx.Listing(prefix), nil
 }
 
-func (s *S3svc) ListVersions(bucket, prefix string) (*s3.ListObjectVersionsOutput, error) {
+func (s *S3svc) listAllVersions(ctx context.Context, bucket, prefix string) (*s3.ListObjectVersionsOutput, error) {
+
+	if s.ListParallelism > 1 {
+		return s.listVersionsParallel(ctx, bucket, prefix, s.ListParallelism)
+	}
+	return s.listPrefixVersions(ctx, bucket, prefix)
+}
+
+// listPrefixVersions lists every version under prefix, one page at a time.
+func (s *S3svc) listPrefixVersions(ctx context.Context, bucket, prefix string) (*s3.ListObjectVersionsOutput, error) {
 
 	listVersionsParams := &s3.ListObjectVersionsInput{
 		Bucket: aws.String(bucket),
 		Prefix: aws.String(prefix),
 	}
 
-	listVersionResp, err := s.Svc.ListObjectVersions(listVersionsParams)
+	// Merge all pages, so keys beyond the first 1000 versions get restored too.
+	listVersionResp := &s3.ListObjectVersionsOutput{
+		Name:   aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}
+	req, _ := s.Svc.ListObjectVersionsRequest(listVersionsParams)
+	err := sendPages(ctx, req, func(data interface{}) bool {
+		page := data.(*s3.ListObjectVersionsOutput)
+		listVersionResp.Versions = append(listVersionResp.Versions, page.Versions...)
+		listVersionResp.DeleteMarkers = append(listVersionResp.DeleteMarkers, page.DeleteMarkers...)
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
 	return listVersionResp, nil
 }
 
-func (s *S3svc) CopyObject(bucket, key, version string) (*s3.CopyObjectOutput, error) {
+func (s *S3svc) CopyObject(ctx context.Context, bucket, key, version string) (*s3.CopyObjectOutput, error) {
 
 	copyParams := &s3.CopyObjectInput{
 		Bucket:     aws.String(bucket),
 		CopySource: aws.String(bucket + "/" + key + "?versionId=" + version),
 		Key:        aws.String(key),
 	}
-	copyResp, err := s.Svc.CopyObject(copyParams)
-	if err != nil {
+	req, copyResp := s.Svc.CopyObjectRequest(copyParams)
+	if err := send(ctx, req); err != nil {
 		return nil, err
 	}
 	return copyResp, nil
 }
 
-func (s *S3svc) RestoreObjects(bucket string, versions *s3.ListObjectVersionsOutput, restoreTime time.Time) error {
+// DeleteObject places a delete marker on top of key, leaving its versions
+// intact.
+func (s *S3svc) DeleteObject(ctx context.Context, bucket, key string) (*s3.DeleteObjectOutput, error) {
+
+	deleteParams := &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	req, deleteResp := s.Svc.DeleteObjectRequest(deleteParams)
+	if err := send(ctx, req); err != nil {
+		return nil, err
+	}
+	return deleteResp, nil
+}
+
+// CheckVersioning returns a NotVersionedError unless versioning is enabled on
+// bucket, as restoring objects without it would overwrite the versions being
+// replaced. If the credentials used can't read the versioning status it's
+// taken to be enabled.
+func (s *S3svc) CheckVersioning(ctx context.Context, bucket string) error {
+
+	req, versioningResp := s.Svc.GetBucketVersioningRequest(&s3.GetBucketVersioningInput{
+		Bucket: aws.String(bucket),
+	})
+	err := send(ctx, req)
+	if _, ok := err.(*PermissionError); ok {
+		s.Log.Debug("can't check versioning", "bucket", bucket, "error", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if status := aws.StringValue(versioningResp.Status); status != s3.BucketVersioningStatusEnabled {
+		return &NotVersionedError{Bucket: bucket, Status: status}
+	}
+	return nil
+}
+
+// GetVersion returns a single object version, including its body, which the
+// caller must close.
+func (s *S3svc) GetVersion(ctx context.Context, bucket, key, version string) (*s3.GetObjectOutput, error) {
+
+	getParams := &s3.GetObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(version),
+	}
+	req, getResp := s.Svc.GetObjectRequest(getParams)
+	if err := send(ctx, req); err != nil {
+		return nil, err
+	}
+	return getResp, nil
+}
 
-	var restored map[string]bool
-	restored = make(map[string]bool)
+// deleteMarkerError is returned by HeadVersion for a delete marker, which S3
+// refuses HEAD requests of with 405 Method Not Allowed.
+type deleteMarkerError struct {
+	error
+}
+
+// HeadVersion returns the metadata of a single object version. Responses are
+// cached, as versions are immutable and filters may ask for the same one twice.
+func (s *S3svc) HeadVersion(ctx context.Context, bucket, key, version string) (*s3.HeadObjectOutput, error) {
+
+	cacheKey := key + "?versionId=" + version
+	s.cacheLock.Lock()
+	head, ok := s.heads[cacheKey]
+	s.cacheLock.Unlock()
+	if ok {
+		return head, nil
+	}
+
+	headParams := &s3.HeadObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(version),
+	}
+	req, headResp := s.Svc.HeadObjectRequest(headParams)
+	if err := send(ctx, req); err != nil {
+		if req.HTTPResponse != nil && req.HTTPResponse.StatusCode == http.StatusMethodNotAllowed &&
+			req.HTTPResponse.Header.Get("X-Amz-Delete-Marker") == "true" {
+			return nil, &deleteMarkerError{err}
+		}
+		return nil, err
+	}
+
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+	if s.heads == nil {
+		s.heads = make(map[string]*s3.HeadObjectOutput)
+	}
+	s.heads[cacheKey] = headResp
+	return headResp, nil
+}
+
+// getObjectTaggingInput and getObjectTaggingOutput describe the
+// GetObjectTagging operation, which the vendored SDK predates.
+type getObjectTaggingInput struct {
+	_ struct{} `type:"structure"`
+
+	Bucket    *string `location:"uri" locationName:"Bucket" type:"string" required:"true"`
+	Key       *string `location:"uri" locationName:"Key" min:"1" type:"string" required:"true"`
+	VersionId *string `location:"querystring" locationName:"versionId" type:"string"`
+}
+
+type getObjectTaggingOutput struct {
+	_ struct{} `type:"structure"`
+
+	TagSet []*s3.Tag `locationNameList:"Tag" type:"list" required:"true"`
+}
+
+// VersionTags returns the tag set of a single object version. Like
+// HeadVersion, responses are cached.
+func (s *S3svc) VersionTags(ctx context.Context, bucket, key, version string) ([]*s3.Tag, error) {
+
+	cacheKey := key + "?versionId=" + version
+	s.cacheLock.Lock()
+	tags, ok := s.tags[cacheKey]
+	s.cacheLock.Unlock()
+	if ok {
+		return tags, nil
+	}
+
+	op := &request.Operation{
+		Name:       "GetObjectTagging",
+		HTTPMethod: "GET",
+		HTTPPath:   "/{Bucket}/{Key+}?tagging",
+	}
+	tagParams := &getObjectTaggingInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(version),
+	}
+	tagResp := &getObjectTaggingOutput{}
+	if err := send(ctx, s.Svc.NewRequest(op, tagParams, tagResp)); err != nil {
+		return nil, err
+	}
+
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+	if s.tags == nil {
+		s.tags = make(map[string][]*s3.Tag)
+	}
+	s.tags[cacheKey] = tagResp.TagSet
+	return tagResp.TagSet, nil
+}
+
+// SelectVersions returns the version every key is restored to: the most
+// recent one older than restoreTime which none of the filters skip. Keys
+// without such a version, or deleted after it, are left out.
+func (s *S3svc) SelectVersions(ctx context.Context, bucket string, versions *s3.ListObjectVersionsOutput, restoreTime time.Time) ([]*s3.ObjectVersion, error) {
+
+	var keys []string
+	byKey := make(map[string][]*s3.ObjectVersion)
 	for _, version := range versions.Versions {
-		if _, ok := restored[*version.Key]; !ok {
-			// Amazon S3 returns object versions in the order in which they were stored,
-			// with the most recently stored returned first.
-			if restoreTime.After(*version.LastModified) {
-				var copyResp *s3.CopyObjectOutput
-				if !*version.IsLatest {
-					fmt.Printf("Restoring...\n %s\n", version)
-					var err error
-					copyResp, err = s.CopyObject(bucket, *version.Key, *version.VersionId)
-					if err != nil {
-						return err
-					}
-					fmt.Printf("Restored:\n %s\n", copyResp)
-				}
-				restored[*version.Key] = true
+		if _, ok := byKey[*version.Key]; !ok {
+			keys = append(keys, *version.Key)
+		}
+		byKey[*version.Key] = append(byKey[*version.Key], version)
+	}
+	markers := make(map[string][]*s3.DeleteMarkerEntry)
+	for _, marker := range versions.DeleteMarkers {
+		markers[*marker.Key] = append(markers[*marker.Key], marker)
+	}
+
+	var selected []*s3.ObjectVersion
+	for _, key := range keys {
+		version, err := s.selectVersion(ctx, bucket, byKey[key], markers[key], restoreTime)
+		if err != nil {
+			return nil, err
+		}
+		if version != nil {
+			selected = append(selected, version)
+		}
+	}
+	return selected, nil
+}
+
+// selectVersion returns the version of a single key to restore, or nil if it
+// has none: the most recent one before restoreTime not skipped by a filter,
+// unless one of markers deleted the key after it and before restoreTime. This
+// is the state of the key at restoreTime for every command.
+func (s *S3svc) selectVersion(ctx context.Context, bucket string, versions []*s3.ObjectVersion, markers []*s3.DeleteMarkerEntry, restoreTime time.Time) (*s3.ObjectVersion, error) {
+
+	var deleted time.Time
+	for _, marker := range markers {
+		if restoreTime.After(*marker.LastModified) && marker.LastModified.After(deleted) {
+			deleted = *marker.LastModified
+		}
+	}
+
+	// Amazon S3 returns object versions in the order in which they were stored,
+	// with the most recently stored returned first.
+	for _, version := range versions {
+		if restoreTime.After(*version.LastModified) {
+			if deleted.After(*version.LastModified) {
+				return nil, nil
+			}
+			skip, err := s.SkipVersion(ctx, bucket, version)
+			if err != nil {
+				return nil, err
 			}
+			if skip {
+				s.Log.Info("skipping version", "action", "skip", "bucket", bucket, "key", *version.Key, "version_id", *version.VersionId)
+				s.Progress.AddSkipped()
+				continue
+			}
+			return version, nil
 		}
 	}
-	return nil
+	return nil, nil
+}
+
+// PlanRestore returns the versions RestoreObjects copies: the selected ones
+// which aren't the latest version of their key already.
+func (s *S3svc) PlanRestore(ctx context.Context, bucket string, versions *s3.ListObjectVersionsOutput, restoreTime time.Time) ([]*s3.ObjectVersion, error) {
+
+	selected, err := s.SelectVersions(ctx, bucket, versions, restoreTime)
+	if err != nil {
+		return nil, err
+	}
+	var plan []*s3.ObjectVersion
+	for _, version := range selected {
+		if !*version.IsLatest && !s.protectedVersion(bucket, version) {
+			plan = append(plan, version)
+		}
+	}
+	return plan, nil
+}
+
+func (s *S3svc) RestoreObjects(ctx context.Context, bucket string, versions *s3.ListObjectVersionsOutput, restoreTime time.Time) error {
+
+	plan, err := s.PlanRestore(ctx, bucket, versions, restoreTime)
+	if err != nil {
+		return err
+	}
+	if err := s.checkLimits(len(plan), planBytes(plan)); err != nil {
+		return err
+	}
+	summary := PlanSummary{Bucket: bucket, Prefix: aws.StringValue(versions.Prefix), Timestamp: restoreTime, Objects: len(plan), Bytes: planBytes(plan)}
+	if err := s.confirm(summary); err != nil {
+		return err
+	}
+	replaced := make(map[string]string)
+	for _, version := range versions.Versions {
+		if *version.IsLatest {
+			replaced[*version.Key] = *version.VersionId
+		}
+	}
+	for _, marker := range versions.DeleteMarkers {
+		if *marker.IsLatest {
+			replaced[*marker.Key] = *marker.VersionId
+		}
+	}
+	return s.copyPlan(ctx, bucket, plan, replaced)
+}
+
+// copyPlan copies the versions in plan over their keys, whose latest versions
+// or delete markers are in replaced.
+func (s *S3svc) copyPlan(ctx context.Context, bucket string, plan []*s3.ObjectVersion, replaced map[string]string) error {
+
+	var err error
+	mismatches := s.mismatchCount()
+	pool := s.startCopies(ctx, bucket)
+	for _, version := range plan {
+		if err = pool.Submit(version, replaced[*version.Key]); err != nil {
+			break
+		}
+	}
+	if waitErr := pool.Wait(); err == nil {
+		err = waitErr
+	}
+	if err == nil {
+		err = s.verificationError(mismatches)
+	}
+	return err
+}
+
+// WalkPlan calls fn with each version a restore of prefix copies and the ID
+// of the latest version or delete marker of its key, which the copy replaces,
+// deciding one key at a time as its versions are listed.
+func (s *S3svc) WalkPlan(ctx context.Context, bucket, prefix string, restoreTime time.Time, fn func(version *s3.ObjectVersion, replaced string) error) error {
+
+	err := s.WalkVersions(ctx, bucket, prefix, func(key *KeyVersions) error {
+		s.Progress.AddListed()
+		version, err := s.selectVersion(ctx, bucket, key.Versions, key.DeleteMarkers, restoreTime)
+		if err != nil || version == nil || *version.IsLatest || s.protectedVersion(bucket, version) {
+			return err
+		}
+		s.Progress.AddPlanned(aws.Int64Value(version.Size))
+		return fn(version, latestVersionId(key))
+	})
+	s.Progress.DoneListing()
+	return err
+}
+
+// latestVersionId returns the ID of the latest version or delete marker of
+// key.
+func latestVersionId(key *KeyVersions) string {
+	for _, version := range key.Versions {
+		if *version.IsLatest {
+			return *version.VersionId
+		}
+	}
+	for _, marker := range key.DeleteMarkers {
+		if *marker.IsLatest {
+			return *marker.VersionId
+		}
+	}
+	return ""
+}
+
+// RestorePrefix restores the objects under prefix like RestoreObjects, but
+// restores each key as soon as its versions are listed rather than listing
+// the whole prefix first. Once ctx is done listing stops and no more copies
+// are started, but those in progress finish, and a CanceledError is returned.
+// If the restore has limits or must be confirmed the whole plan is listed
+// first after all.
+func (s *S3svc) RestorePrefix(ctx context.Context, bucket, prefix string, restoreTime time.Time) error {
+
+	if err := s.CheckVersioning(ctx, bucket); err != nil {
+		return err
+	}
+	if s.guarded() {
+		return s.restoreGuarded(ctx, bucket, prefix, restoreTime)
+	}
+	mismatches := s.mismatchCount()
+	pool := s.startCopies(ctx, bucket)
+	err := s.WalkPlan(ctx, bucket, prefix, restoreTime, pool.Submit)
+	if waitErr := pool.Wait(); err == nil {
+		err = waitErr
+	}
+	if err == nil {
+		err = s.verificationError(mismatches)
+	}
+	return err
 }
 
 func parseTimestamp(timestamp string) (restoreTime time.Time) {
 
 	i, err := strconv.ParseInt(timestamp, 10, 64)
 	if err != nil {
-		log.Fatal(err)
+		exit("invalid arguments", usageError(err))
 	}
 	return time.Unix(i, 0)
 
@@ -111,13 +531,63 @@ func printUsage(command string, usage func()) func() {
 			fmt.Fprintf(os.Stderr, " restore   Restore bucket objects\n")
 			usage()
 		}
+	case "rollback":
+		return func() {
+			fmt.Fprintf(os.Stderr, " rollback   Revert objects changed within a time window\n")
+			usage()
+		}
+	case "restore-version":
+		return func() {
+			fmt.Fprintf(os.Stderr, " restore-version   Restore specific object versions\n")
+			usage()
+		}
+	case "verify":
+		return func() {
+			fmt.Fprintf(os.Stderr, " verify   Check bucket objects match a point in time\n")
+			usage()
+		}
+	case "diff":
+		return func() {
+			fmt.Fprintf(os.Stderr, " diff   Show changes between two points in time\n")
+			usage()
+		}
+	case "history":
+		return func() {
+			fmt.Fprintf(os.Stderr, " history   Show every version of an object\n")
+			usage()
+		}
+	case "cat":
+		return func() {
+			fmt.Fprintf(os.Stderr, " cat   Print an object as it was at a point in time\n")
+			usage()
+		}
+	case "content-diff":
+		return func() {
+			fmt.Fprintf(os.Stderr, " content-diff   Show changes to an object between two points in time\n")
+			usage()
+		}
+	case "shell":
+		return func() {
+			fmt.Fprintf(os.Stderr, " shell   Browse a bucket as it was at a point in time\n")
+			usage()
+		}
+	case "serve":
+		return func() {
+			fmt.Fprintf(os.Stderr, " serve   Serve a bucket as it was at a point in time over the S3 API\n")
+			usage()
+		}
+	case "plan":
+		return func() {
+			fmt.Fprintf(os.Stderr, " plan   Show what restore would do\n")
+			usage()
+		}
 	case "list":
 		return func() {
-			fmt.Fprintf(os.Stderr, " list   List object versions. Not implemented\n")
+			fmt.Fprintf(os.Stderr, " list   List object versions\n")
 			usage()
 		}
 	default:
-		fmt.Fprintf(os.Stderr, " restore   Restore bucket objects\n list   List object versions\n")
+		fmt.Fprintf(os.Stderr, " restore   Restore bucket objects\n plan   Show what restore would do\n rollback   Revert objects changed within a time window\n restore-version   Restore specific object versions\n verify   Check bucket objects match a point in time\n diff   Show changes between two points in time\n history   Show every version of an object\n cat   Print an object as it was at a point in time\n content-diff   Show changes to an object between two points in time\n shell   Browse a bucket as it was at a point in time\n serve   Serve a bucket as it was at a point in time over the S3 API\n list   List object versions\n")
 		return nil
 	}
 }
@@ -127,9 +597,117 @@ func parseArguments() ParsedArgs {
 	bkt := restoreCommand.String("bucket", "", "Source bucket. Default none. Required.")
 	ts := restoreCommand.String("timestamp", "", "Restore point in time in UNIX timestamp format. Required.")
 	prx := restoreCommand.String("prefix", "", "Object prefix. Default none.")
+	inv := restoreCommand.String("inventory", "", "S3 Inventory manifest.json, as s3://bucket/key or a local path, to read versions from instead of listing them. Default none.")
+	filterArgs := addFilterFlags(restoreCommand)
+	indexArgs := addListingFlags(restoreCommand)
+	guardArgs := addGuardFlags(restoreCommand)
+
+	planCommand := flag.NewFlagSet("plan", flag.ExitOnError)
+	pBkt := planCommand.String("bucket", "", "Source bucket. Default none. Required.")
+	pTs := planCommand.String("timestamp", "", "Restore point in time in UNIX timestamp format. Required.")
+	pPrx := planCommand.String("prefix", "", "Object prefix. Default none.")
+	pInv := planCommand.String("inventory", "", "S3 Inventory manifest.json, as s3://bucket/key or a local path, to read versions from instead of listing them. Default none.")
+	pFilterArgs := addFilterFlags(planCommand)
+	pIndexArgs := addListingFlags(planCommand)
+	pProtectArgs := addProtectFlags(planCommand)
+	pProgressInterval := planCommand.Duration("progress-interval", 10*time.Second, "How often to log progress when not on a terminal. 0 disables progress.")
+	pBatchManifest := planCommand.String("emit-batch-manifest", "", "Also write the versions to restore to this file as an S3 Batch Operations CSV manifest. Default none.")
+	pBatchJob := planCommand.String("emit-batch-job", "", "Also write a Batch Operations Copy job restoring the manifest to this file. Requires -emit-batch-manifest. Default none.")
+	pBatchManifestURL := planCommand.String("batch-manifest-url", "", "Where the manifest will be uploaded to, as s3://bucket/key. Required by -emit-batch-job.")
+	pBatchRole := planCommand.String("batch-role-arn", "", "IAM role the job runs as. Required by -emit-batch-job.")
+	pBatchAccount := planCommand.String("batch-account-id", "", "AWS account ID the job runs in. Required by -emit-batch-job.")
+	pBatchReport := planCommand.String("batch-report-url", "", "Where the job writes its completion report, as s3://bucket/prefix. Default none.")
+	verify := restoreCommand.Bool("verify", false, "Check restored objects match the versions they were restored from.")
+	concurrency := restoreCommand.Int("concurrency", 1, "Number of objects to restore at once. Lowered while S3 asks to slow down.")
+	progressInterval := restoreCommand.Duration("progress-interval", 10*time.Second, "How often to log progress when not on a terminal. 0 disables progress.")
+	maxRequests := restoreCommand.String("max-requests-per-second", "", "Comma-separated limits on copies a second, overall or as prefix=limit for keys under prefix. Default none.")
+	maxBytes := restoreCommand.String("max-bytes-per-second", "", "Comma-separated limits on bytes copied a second, overall or as prefix=limit for keys under prefix. Default none.")
+	notifyURL := restoreCommand.String("notify-url", "", "Webhook to POST JSON events to when the restore starts, passes 25, 50 and 75%, completes or fails. Default none.")
+	notifyTemplate := restoreCommand.String("notify-template", "", "File with a Go template of the webhook request body, or slack for Slack messages. Default the event as JSON.")
+	report := restoreCommand.String("report", "", "File to write a summary of the restore to, as HTML if it ends in .html, otherwise JSON. Default none.")
+
+	rollbackCommand := flag.NewFlagSet("rollback", flag.ExitOnError)
+	rbBkt := rollbackCommand.String("bucket", "", "Source bucket. Default none. Required.")
+	rbFrom := rollbackCommand.String("from", "", "Start of the window to revert in UNIX timestamp format. Required.")
+	rbTo := rollbackCommand.String("to", "", "End of the window to revert in UNIX timestamp format. Required.")
+	rbPrx := rollbackCommand.String("prefix", "", "Object prefix. Default none.")
+	rbVerify := rollbackCommand.Bool("verify", false, "Check restored objects match the versions they were restored from.")
+	rbGuardArgs := addGuardFlags(rollbackCommand)
+
+	restoreVersionCommand := flag.NewFlagSet("restore-version", flag.ExitOnError)
+	rvBkt := restoreVersionCommand.String("bucket", "", "Source bucket. Default none. Required.")
+	rvKey := restoreVersionCommand.String("key", "", "Object key. Required unless -csv is given.")
+	rvVersion := restoreVersionCommand.String("version-id", "", "Version to restore. Required unless -csv is given.")
+	rvCSV := restoreVersionCommand.String("csv", "", "CSV file of key,versionId pairs to restore. Default none.")
+	rvVerify := restoreVersionCommand.Bool("verify", false, "Check restored objects match the versions they were restored from.")
+	rvGuardArgs := addGuardFlags(restoreVersionCommand)
+
+	verifyCommand := flag.NewFlagSet("verify", flag.ExitOnError)
+	vBkt := verifyCommand.String("bucket", "", "Source bucket. Default none. Required.")
+	vTs := verifyCommand.String("timestamp", "", "Point in time to compare with in UNIX timestamp format. Required.")
+	vPrx := verifyCommand.String("prefix", "", "Object prefix. Default none.")
+	vFilterArgs := addFilterFlags(verifyCommand)
+
+	diffCommand := flag.NewFlagSet("diff", flag.ExitOnError)
+	dBkt := diffCommand.String("bucket", "", "Source bucket. Default none. Required.")
+	dFrom := diffCommand.String("from", "", "Earlier point in time in UNIX timestamp format. Required.")
+	dTo := diffCommand.String("to", "", "Later point in time in UNIX timestamp format. Required.")
+	dPrx := diffCommand.String("prefix", "", "Object prefix. Default none.")
+	dFormat := diffCommand.String("format", "text", "Output format: text, json or csv.")
+	dIndexArgs := addListingFlags(diffCommand)
+
+	historyCommand := flag.NewFlagSet("history", flag.ExitOnError)
+	hBkt := historyCommand.String("bucket", "", "Source bucket. Default none. Required.")
+	hKey := historyCommand.String("key", "", "Object key. Required.")
+	hTs := historyCommand.String("timestamp", "", "Mark the version restore would pick for this UNIX timestamp. Default none.")
+	hWriter := historyCommand.String("writer-metadata", "", "User metadata naming who wrote a version, looked up with a HEAD request per version. Default none, the version's owner.")
+	hFilterArgs := addFilterFlags(historyCommand)
+	hIndexArgs := addListingFlags(historyCommand)
+
+	catCommand := flag.NewFlagSet("cat", flag.ExitOnError)
+	cBkt := catCommand.String("bucket", "", "Source bucket. Default none. Required.")
+	cKey := catCommand.String("key", "", "Object key. Required.")
+	cTs := catCommand.String("timestamp", "", "Point in time in UNIX timestamp format. Required unless -version-id is given.")
+	cVersion := catCommand.String("version-id", "", "Version to print. Required unless -timestamp is given.")
+
+	contentDiffCommand := flag.NewFlagSet("content-diff", flag.ExitOnError)
+	cdBkt := contentDiffCommand.String("bucket", "", "Source bucket. Default none. Required.")
+	cdKey := contentDiffCommand.String("key", "", "Object key. Required.")
+	cdFrom := contentDiffCommand.String("from", "", "Earlier point in time in UNIX timestamp format. Required.")
+	cdTo := contentDiffCommand.String("to", "", "Later point in time in UNIX timestamp format. Required.")
+	cdMaxSize := contentDiffCommand.Int64("max-size", 1<<20, "Refuse to compare versions larger than this many bytes.")
+
+	shellCommand := flag.NewFlagSet("shell", flag.ExitOnError)
+	shBkt := shellCommand.String("bucket", "", "Source bucket. Default none. Required.")
+	shTs := shellCommand.String("timestamp", "", "Point in time to start browsing at in UNIX timestamp format. Required.")
+	shGuardArgs := addGuardFlags(shellCommand)
+
+	serveCommand := flag.NewFlagSet("serve", flag.ExitOnError)
+	svBkt := serveCommand.String("bucket", "", "Source bucket. Default none. Required.")
+	svTs := serveCommand.String("timestamp", "", "Point in time to serve in UNIX timestamp format. Required.")
+	svListen := serveCommand.String("listen", "127.0.0.1:9000", "Address to listen on. Requests aren't authenticated, so other addresses expose the bucket's history to the network.")
 
 	listCommand := flag.NewFlagSet("list", flag.ExitOnError)
-	since := listCommand.String("since", "", "Not implemented")
+	lBkt := listCommand.String("bucket", "", "Source bucket. Default none. Required.")
+	lPrx := listCommand.String("prefix", "", "Object prefix. Default none.")
+	since := listCommand.String("since", "", "Only list versions created since this UNIX timestamp. Default none.")
+	lIndexArgs := addListingFlags(listCommand)
+
+	commonArgs := make(map[string]func(args map[string]string) map[string]string)
+	for _, command := range []*flag.FlagSet{
+		restoreCommand, planCommand, rollbackCommand, restoreVersionCommand, verifyCommand, diffCommand,
+		historyCommand, catCommand, contentDiffCommand, shellCommand, serveCommand, listCommand,
+	} {
+		commonArgs[command.Name()] = addLogFlags(command)
+	}
+	// Commands which change or check a bucket can report how it went to
+	// Prometheus.
+	for _, command := range []*flag.FlagSet{restoreCommand, rollbackCommand, restoreVersionCommand, verifyCommand} {
+		logArgs, metricsArgs := commonArgs[command.Name()], addMetricsFlags(command)
+		commonArgs[command.Name()] = func(args map[string]string) map[string]string {
+			return metricsArgs(logArgs(args))
+		}
+	}
 
 	if len(os.Args) == 1 {
 		printUsage("", func() {})
@@ -139,7 +717,7 @@ func parseArguments() ParsedArgs {
 	switch os.Args[1] {
 	case "restore":
 		if err := restoreCommand.Parse(os.Args[2:]); err != nil {
-			log.Fatal(err)
+			exit("invalid arguments", usageError(err))
 		}
 		if *bkt == "" || *ts == "" {
 			restoreCommand.Usage = printUsage("restore", restoreCommand.PrintDefaults)
@@ -148,50 +726,678 @@ func parseArguments() ParsedArgs {
 		}
 		return ParsedArgs{
 			CommandName: "restore",
-			Args: map[string]string{
-				"bucket":    *bkt,
-				"timestamp": *ts,
-				"prefix":    *prx,
-			},
+			Args: commonArgs["restore"](guardArgs(indexArgs(filterArgs(map[string]string{
+				"bucket":                  *bkt,
+				"timestamp":               *ts,
+				"prefix":                  *prx,
+				"inventory":               *inv,
+				"verify":                  strconv.FormatBool(*verify),
+				"concurrency":             strconv.Itoa(*concurrency),
+				"max-requests-per-second": *maxRequests,
+				"max-bytes-per-second":    *maxBytes,
+				"progress-interval":       progressInterval.String(),
+				"report":                  *report,
+				"notify-url":              *notifyURL,
+				"notify-template":         *notifyTemplate,
+			})))),
+		}
+
+	case "plan":
+		if err := planCommand.Parse(os.Args[2:]); err != nil {
+			exit("invalid arguments", usageError(err))
+		}
+		batchJobIncomplete := *pBatchJob != "" && (*pBatchManifest == "" || *pBatchManifestURL == "" || *pBatchRole == "" || *pBatchAccount == "")
+		if *pBkt == "" || *pTs == "" || batchJobIncomplete {
+			planCommand.Usage = printUsage("plan", planCommand.PrintDefaults)
+			planCommand.Usage()
+			os.Exit(2)
+		}
+		return ParsedArgs{
+			CommandName: "plan",
+			Args: commonArgs["plan"](pProtectArgs(pIndexArgs(pFilterArgs(map[string]string{
+				"bucket":              *pBkt,
+				"timestamp":           *pTs,
+				"prefix":              *pPrx,
+				"inventory":           *pInv,
+				"emit-batch-manifest": *pBatchManifest,
+				"emit-batch-job":      *pBatchJob,
+				"batch-manifest-url":  *pBatchManifestURL,
+				"batch-role-arn":      *pBatchRole,
+				"batch-account-id":    *pBatchAccount,
+				"batch-report-url":    *pBatchReport,
+				"progress-interval":   pProgressInterval.String(),
+			})))),
+		}
+
+	case "rollback":
+		if err := rollbackCommand.Parse(os.Args[2:]); err != nil {
+			exit("invalid arguments", usageError(err))
+		}
+		if *rbBkt == "" || *rbFrom == "" || *rbTo == "" {
+			rollbackCommand.Usage = printUsage("rollback", rollbackCommand.PrintDefaults)
+			rollbackCommand.Usage()
+			os.Exit(2)
+		}
+		return ParsedArgs{
+			CommandName: "rollback",
+			Args: commonArgs["rollback"](rbGuardArgs(map[string]string{
+				"bucket": *rbBkt,
+				"from":   *rbFrom,
+				"to":     *rbTo,
+				"prefix": *rbPrx,
+				"verify": strconv.FormatBool(*rbVerify),
+			})),
+		}
+
+	case "restore-version":
+		if err := restoreVersionCommand.Parse(os.Args[2:]); err != nil {
+			exit("invalid arguments", usageError(err))
+		}
+		if *rvBkt == "" || (*rvCSV == "") == (*rvKey == "" || *rvVersion == "") {
+			restoreVersionCommand.Usage = printUsage("restore-version", restoreVersionCommand.PrintDefaults)
+			restoreVersionCommand.Usage()
+			os.Exit(2)
+		}
+		return ParsedArgs{
+			CommandName: "restore-version",
+			Args: commonArgs["restore-version"](rvGuardArgs(map[string]string{
+				"bucket":     *rvBkt,
+				"key":        *rvKey,
+				"version-id": *rvVersion,
+				"csv":        *rvCSV,
+				"verify":     strconv.FormatBool(*rvVerify),
+			})),
+		}
+
+	case "verify":
+		if err := verifyCommand.Parse(os.Args[2:]); err != nil {
+			exit("invalid arguments", usageError(err))
+		}
+		if *vBkt == "" || *vTs == "" {
+			verifyCommand.Usage = printUsage("verify", verifyCommand.PrintDefaults)
+			verifyCommand.Usage()
+			os.Exit(2)
+		}
+		return ParsedArgs{
+			CommandName: "verify",
+			Args: commonArgs["verify"](vFilterArgs(map[string]string{
+				"bucket":    *vBkt,
+				"timestamp": *vTs,
+				"prefix":    *vPrx,
+			})),
+		}
+
+	case "diff":
+		if err := diffCommand.Parse(os.Args[2:]); err != nil {
+			exit("invalid arguments", usageError(err))
+		}
+		if *dBkt == "" || *dFrom == "" || *dTo == "" {
+			diffCommand.Usage = printUsage("diff", diffCommand.PrintDefaults)
+			diffCommand.Usage()
+			os.Exit(2)
+		}
+		return ParsedArgs{
+			CommandName: "diff",
+			Args: commonArgs["diff"](dIndexArgs(map[string]string{
+				"bucket": *dBkt,
+				"from":   *dFrom,
+				"to":     *dTo,
+				"prefix": *dPrx,
+				"format": *dFormat,
+			})),
+		}
+
+	case "history":
+		if err := historyCommand.Parse(os.Args[2:]); err != nil {
+			exit("invalid arguments", usageError(err))
+		}
+		if *hBkt == "" || *hKey == "" {
+			historyCommand.Usage = printUsage("history", historyCommand.PrintDefaults)
+			historyCommand.Usage()
+			os.Exit(2)
+		}
+		return ParsedArgs{
+			CommandName: "history",
+			Args: commonArgs["history"](hIndexArgs(hFilterArgs(map[string]string{
+				"bucket":          *hBkt,
+				"key":             *hKey,
+				"timestamp":       *hTs,
+				"writer-metadata": *hWriter,
+			}))),
+		}
+
+	case "cat":
+		if err := catCommand.Parse(os.Args[2:]); err != nil {
+			exit("invalid arguments", usageError(err))
+		}
+		if *cBkt == "" || *cKey == "" || (*cTs == "") == (*cVersion == "") {
+			catCommand.Usage = printUsage("cat", catCommand.PrintDefaults)
+			catCommand.Usage()
+			os.Exit(2)
+		}
+		return ParsedArgs{
+			CommandName: "cat",
+			Args: commonArgs["cat"](map[string]string{
+				"bucket":     *cBkt,
+				"key":        *cKey,
+				"timestamp":  *cTs,
+				"version-id": *cVersion,
+			}),
+		}
+
+	case "content-diff":
+		if err := contentDiffCommand.Parse(os.Args[2:]); err != nil {
+			exit("invalid arguments", usageError(err))
+		}
+		if *cdBkt == "" || *cdKey == "" || *cdFrom == "" || *cdTo == "" {
+			contentDiffCommand.Usage = printUsage("content-diff", contentDiffCommand.PrintDefaults)
+			contentDiffCommand.Usage()
+			os.Exit(2)
+		}
+		return ParsedArgs{
+			CommandName: "content-diff",
+			Args: commonArgs["content-diff"](map[string]string{
+				"bucket":   *cdBkt,
+				"key":      *cdKey,
+				"from":     *cdFrom,
+				"to":       *cdTo,
+				"max-size": strconv.FormatInt(*cdMaxSize, 10),
+			}),
+		}
+
+	case "shell":
+		if err := shellCommand.Parse(os.Args[2:]); err != nil {
+			exit("invalid arguments", usageError(err))
+		}
+		if *shBkt == "" || *shTs == "" {
+			shellCommand.Usage = printUsage("shell", shellCommand.PrintDefaults)
+			shellCommand.Usage()
+			os.Exit(2)
+		}
+		return ParsedArgs{
+			CommandName: "shell",
+			Args: commonArgs["shell"](shGuardArgs(map[string]string{
+				"bucket":    *shBkt,
+				"timestamp": *shTs,
+			})),
+		}
+
+	case "serve":
+		if err := serveCommand.Parse(os.Args[2:]); err != nil {
+			exit("invalid arguments", usageError(err))
+		}
+		if *svBkt == "" || *svTs == "" {
+			serveCommand.Usage = printUsage("serve", serveCommand.PrintDefaults)
+			serveCommand.Usage()
+			os.Exit(2)
+		}
+		return ParsedArgs{
+			CommandName: "serve",
+			Args: commonArgs["serve"](map[string]string{
+				"bucket":    *svBkt,
+				"timestamp": *svTs,
+				"listen":    *svListen,
+			}),
 		}
 
 	case "list":
 		if err := listCommand.Parse(os.Args[2:]); err != nil {
-			log.Fatal(err)
+			exit("invalid arguments", usageError(err))
 		}
-		restoreCommand.Usage = printUsage("list", listCommand.PrintDefaults)
-		restoreCommand.Usage()
-		fmt.Println(*since)
-		os.Exit(2)
+		if *lBkt == "" {
+			listCommand.Usage = printUsage("list", listCommand.PrintDefaults)
+			listCommand.Usage()
+			os.Exit(2)
+		}
+		return ParsedArgs{
+			CommandName: "list",
+			Args: commonArgs["list"](lIndexArgs(map[string]string{
+				"bucket": *lBkt,
+				"prefix": *lPrx,
+				"since":  *since,
+			})),
+		}
+
 	default:
-		fmt.Fprintf(os.Stderr, "%q is not valid command.\n", os.Args[1])
+		logger.Error(fmt.Sprintf("%q is not valid command.", os.Args[1]))
 		os.Exit(2)
 	}
 	return ParsedArgs{}
 }
 
+func printMismatches(mismatches []Mismatch) {
+	if len(mismatches) == 0 {
+		return
+	}
+	fmt.Printf("Verification failed:\n")
+	for _, mismatch := range mismatches {
+		fmt.Printf(" %s %s -> %s: %s\n", mismatch.Key, mismatch.VersionId, mismatch.NewVersionId, mismatch.Reason)
+	}
+}
+
+// exit logs err and exits with the status ExitCode gives it.
+func exit(msg string, err error) {
+	logger.Error(msg, "error", err, "status", ExitCode(err))
+	os.Exit(ExitCode(err))
+}
+
 func main() {
-	s3svc := NewS3svc()
 	args := parseArguments()
 
+	level, err := ParseLevel(args.Args["log-level"])
+	if err != nil {
+		exit("invalid arguments", usageError(err))
+	}
+	if logger, err = NewLogger(os.Stderr, level, args.Args["log-format"]); err != nil {
+		exit("invalid arguments", usageError(err))
+	}
+	s3svc, err := NewS3svc()
+	if err != nil {
+		exit("failed to create session", err)
+	}
+	s3svc.Log = logger
+	if args.Args["metrics-listen"] != "" || args.Args["metrics-textfile"] != "" {
+		s3svc.Metrics = NewMetrics()
+		s3svc.Metrics.Instrument(s3svc.Svc)
+	}
+	finish := func(err error) {
+		s3svc.Metrics.Finish(err)
+		if path := args.Args["metrics-textfile"]; path != "" {
+			if err := s3svc.Metrics.WriteTextfile(path); err != nil {
+				logger.Error("failed to write metrics", "path", path, "error", err)
+			}
+		}
+	}
+	defer finish(nil)
+	fail := func(err error) {
+		finish(err)
+		exit(args.CommandName+" failed", err)
+	}
+	// Commands which list buckets or change them stop gracefully when
+	// interrupted.
+	ctx := context.Background()
+	switch args.CommandName {
+	case "restore", "plan", "rollback", "restore-version", "verify", "diff", "history", "list":
+		ctx = cancelOnSignal()
+	}
+	if listen := args.Args["metrics-listen"]; listen != "" {
+		if err := s3svc.Metrics.Serve(listen); err != nil {
+			fail(err)
+		}
+	}
+	s3svc.Verify = args.Args["verify"] == "true"
+
+	filters, err := parseFilters(args.Args)
+	if err != nil {
+		fail(usageError(err))
+	}
+	s3svc.Filters = filters
+	if s3svc.Protected, err = parseProtected(args.Args["protect-prefix"]); err != nil {
+		fail(usageError(err))
+	}
+	if args.Args["use-index"] == "true" {
+		s3svc.IndexDir = args.Args["index-dir"]
+	}
+	if args.Args["inventory"] != "" {
+		if s3svc.IndexDir != "" {
+			fail(&UsageError{Message: "-inventory can't be used with -use-index"})
+		}
+		s3svc.Inventory = args.Args["inventory"]
+	}
+	if concurrency, ok := args.Args["concurrency"]; ok {
+		n, err := strconv.Atoi(concurrency)
+		if err != nil {
+			fail(usageError(err))
+		}
+		s3svc.Limiter.SetMax(n)
+	}
+	if s3svc.RequestRate, err = ParseRates(args.Args["max-requests-per-second"]); err != nil {
+		fail(usageError(err))
+	}
+	if s3svc.ByteRate, err = ParseRates(args.Args["max-bytes-per-second"]); err != nil {
+		fail(usageError(err))
+	}
+	stopProgress, startProgress := func() {}, func() {}
+	if interval, ok := args.Args["progress-interval"]; ok {
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			fail(usageError(err))
+		}
+		if d > 0 {
+			s3svc.Progress = NewProgress()
+			startProgress = func() {
+				stopProgress = ShowProgress(s3svc.Progress, logger, isTerminal(os.Stderr), d)
+			}
+			startProgress()
+		}
+	}
+	if parallelism, ok := args.Args["list-parallelism"]; ok {
+		if s3svc.ListParallelism, err = strconv.Atoi(parallelism); err != nil {
+			fail(usageError(err))
+		}
+	}
+	if maxVersions, ok := args.Args["index-max-versions"]; ok {
+		if s3svc.MaxIndexVersions, err = strconv.Atoi(maxVersions); err != nil {
+			fail(usageError(err))
+		}
+	}
+
+	// Commands which change the bucket are limited and confirmed.
+	if yes, ok := args.Args["yes"]; ok {
+		if s3svc.MaxChanges, err = strconv.Atoi(args.Args["max-changes"]); err != nil {
+			fail(usageError(err))
+		}
+		if args.Args["max-bytes"] != "" {
+			if s3svc.MaxBytes, err = parseSize(args.Args["max-bytes"]); err != nil {
+				fail(usageError(err))
+			}
+		}
+		if yes != "true" {
+			if !isTerminal(os.Stdin) {
+				fail(&UsageError{Message: "not asking for confirmation when not run interactively; use -yes"})
+			}
+			// Progress would overwrite the question.
+			prompt := Prompt(os.Stdin, os.Stderr)
+			s3svc.Confirm = func(summary PlanSummary) (bool, error) {
+				stopProgress()
+				defer startProgress()
+				return prompt(summary)
+			}
+		}
+	}
+
 	switch args.CommandName {
 	case "restore":
 		bucket := args.Args["bucket"]
 		prefix := args.Args["prefix"]
 		timestamp := args.Args["timestamp"]
 
-		listVersionResp, err := s3svc.ListVersions(bucket, prefix)
+		restoreTime := parseTimestamp(timestamp)
+		var report *Report
+		if args.Args["report"] != "" {
+			report = NewReport(args.CommandName, args.Args, restoreTime)
+		}
+		var notifier *Notifier
+		if args.Args["notify-url"] != "" {
+			if notifier, err = NewNotifier(args.Args["notify-url"], args.Args["notify-template"]); err != nil {
+				fail(err)
+			}
+			notifier.Log = logger
+			notifier.Base = Event{Command: args.CommandName, Bucket: bucket, Prefix: prefix, Timestamp: restoreTime.UTC()}
+		}
+		if (report != nil || notifier != nil) && s3svc.Progress == nil {
+			s3svc.Progress = NewProgress()
+		}
+
+		notifier.Notify("started", s3svc.Progress, nil)
+		stopNotifying := notifier.WatchProgress(s3svc.Progress, time.Second)
+		err = s3svc.RestorePrefix(ctx, bucket, prefix, restoreTime)
+		stopNotifying()
+		stopProgress()
+		WriteFailures(os.Stdout, s3svc.Failures)
+		printMismatches(s3svc.Mismatches)
+		if report != nil {
+			report.Finish(s3svc, err)
+			if err := report.WriteFile(args.Args["report"]); err != nil {
+				logger.Error("failed to write report", "path", args.Args["report"], "error", err)
+			}
+		}
+		if err != nil {
+			notifier.Notify("failed", s3svc.Progress, err)
+			fail(err)
+		}
+		notifier.Notify("completed", s3svc.Progress, nil)
+
+	case "rollback":
+		bucket := args.Args["bucket"]
+		prefix := args.Args["prefix"]
+
+		from := parseTimestamp(args.Args["from"])
+		to := parseTimestamp(args.Args["to"])
+		if to.Before(from) {
+			fail(&UsageError{Message: "-to must not be before -from"})
+		}
+
+		listVersionResp, err := s3svc.ListVersions(ctx, bucket, prefix)
+		if err != nil {
+			fail(err)
+		}
+
+		conflicts, err := s3svc.RollbackObjects(ctx, bucket, listVersionResp, from, to)
+		WriteFailures(os.Stdout, s3svc.Failures)
+		printMismatches(s3svc.Mismatches)
+		if len(conflicts) > 0 {
+			fmt.Printf("Conflicts, changed both inside and after the window, not rolled back:\n")
+			for _, key := range conflicts {
+				fmt.Printf(" %s\n", key)
+			}
+		}
+		if err != nil {
+			fail(err)
+		}
+		if len(conflicts) > 0 {
+			fail(&ConflictError{Keys: conflicts})
+		}
+
+	case "restore-version":
+		bucket := args.Args["bucket"]
+
+		restores := []VersionRestore{{Key: args.Args["key"], VersionId: args.Args["version-id"]}}
+		if args.Args["csv"] != "" {
+			f, err := os.Open(args.Args["csv"])
+			if err != nil {
+				fail(err)
+			}
+			restores, err = ReadVersionRestores(f)
+			f.Close()
+			if err != nil {
+				fail(err)
+			}
+		}
+
+		restored, err := s3svc.RestoreVersions(ctx, bucket, restores)
+		for _, restore := range restored {
+			fmt.Printf("%s %s -> %s\n", restore.Key, restore.VersionId, restore.NewVersionId)
+		}
+		WriteFailures(os.Stdout, s3svc.Failures)
+		printMismatches(s3svc.Mismatches)
+		if err != nil {
+			fail(err)
+		}
+
+	case "verify":
+		bucket := args.Args["bucket"]
+		prefix := args.Args["prefix"]
+		timestamp := args.Args["timestamp"]
+
+		listVersionResp, err := s3svc.ListVersions(ctx, bucket, prefix)
 		if err != nil {
-			log.Fatal(err.Error())
+			fail(err)
 		}
 
-		restoreTime := parseTimestamp(timestamp)
-		err = s3svc.RestoreObjects(bucket, listVersionResp, restoreTime)
+		differences, err := s3svc.VerifyBucket(ctx, bucket, listVersionResp, parseTimestamp(timestamp))
+		if err != nil {
+			fail(err)
+		}
+		mismatched := Mismatched(differences)
+		switch {
+		case len(mismatched) > 0:
+			fmt.Printf("Bucket doesn't match %s:\n", timestamp)
+		case len(differences) > 0:
+			fmt.Printf("Bucket matches %s, apart from keys created since:\n", timestamp)
+		default:
+			fmt.Printf("Bucket matches %s\n", timestamp)
+		}
+		for _, difference := range differences {
+			fmt.Printf(" %s %s expected=%s current=%s %s\n", difference.Status, difference.Key,
+				difference.ExpectedVersionId, difference.CurrentVersionId, difference.Reason)
+		}
+		if len(mismatched) > 0 {
+			fail(&VerificationError{Differences: mismatched})
+		}
+
+	case "diff":
+		bucket := args.Args["bucket"]
+		prefix := args.Args["prefix"]
+
+		from := parseTimestamp(args.Args["from"])
+		to := parseTimestamp(args.Args["to"])
+		if to.Before(from) {
+			fail(&UsageError{Message: "-to must not be before -from"})
+		}
+
+		listVersionResp, err := s3svc.ListVersions(ctx, bucket, prefix)
+		if err != nil {
+			fail(err)
+		}
+
+		diffs, err := s3svc.DiffBucket(ctx, bucket, listVersionResp, from, to)
+		if err != nil {
+			fail(err)
+		}
+		if err := WriteDiffs(os.Stdout, diffs, args.Args["format"]); err != nil {
+			fail(err)
+		}
+
+	case "history":
+		bucket := args.Args["bucket"]
+		key := args.Args["key"]
+
+		var restoreTime time.Time
+		if args.Args["timestamp"] != "" {
+			restoreTime = parseTimestamp(args.Args["timestamp"])
+		}
+
+		listVersionResp, err := s3svc.ListVersions(ctx, bucket, key)
+		if err != nil {
+			fail(err)
+		}
+
+		entries, err := s3svc.KeyHistory(ctx, bucket, key, listVersionResp, restoreTime, args.Args["writer-metadata"])
+		if err != nil {
+			fail(err)
+		}
+		if len(entries) == 0 {
+			fail(fmt.Errorf("%s has no versions", key))
+		}
+		WriteHistory(os.Stdout, entries)
+
+	case "cat":
+		bucket := args.Args["bucket"]
+		key := args.Args["key"]
+
+		version := args.Args["version-id"]
+		if version == "" {
+			version, err = s3svc.VersionAt(ctx, bucket, key, parseTimestamp(args.Args["timestamp"]))
+			if err != nil {
+				fail(err)
+			}
+		}
+		if err := s3svc.CatVersion(ctx, os.Stdout, bucket, key, version); err != nil {
+			fail(err)
+		}
+
+	case "content-diff":
+		bucket := args.Args["bucket"]
+		key := args.Args["key"]
+
+		maxSize, err := strconv.ParseInt(args.Args["max-size"], 10, 64)
+		if err != nil {
+			fail(err)
+		}
+		fromVersion, err := s3svc.VersionAt(ctx, bucket, key, parseTimestamp(args.Args["from"]))
+		if err != nil {
+			fail(err)
+		}
+		toVersion, err := s3svc.VersionAt(ctx, bucket, key, parseTimestamp(args.Args["to"]))
+		if err != nil {
+			fail(err)
+		}
+		if err := s3svc.ContentDiff(ctx, os.Stdout, bucket, key, fromVersion, toVersion, maxSize); err != nil {
+			fail(err)
+		}
+
+	case "shell":
+		shell := NewShell(s3svc, args.Args["bucket"], parseTimestamp(args.Args["timestamp"]), os.Stdout)
+		if err := shell.Run(os.Stdin); err != nil {
+			fail(err)
+		}
+
+	case "serve":
+		gateway, err := NewGateway(s3svc, args.Args["bucket"], parseTimestamp(args.Args["timestamp"]))
+		if err != nil {
+			fail(err)
+		}
+		if !IsLoopback(args.Args["listen"]) {
+			logger.Warn("serving to the network without authentication", "listen", args.Args["listen"])
+		}
+		logger.Info("serving", "bucket", args.Args["bucket"], "timestamp", args.Args["timestamp"], "listen", args.Args["listen"])
+		fail(NewServer(args.Args["listen"], gateway).ListenAndServe())
+
+	case "plan":
+		bucket := args.Args["bucket"]
+		prefix := args.Args["prefix"]
+		timestamp := args.Args["timestamp"]
+
+		var manifest *BatchManifest
+		if args.Args["emit-batch-manifest"] != "" {
+			f, err := os.Create(args.Args["emit-batch-manifest"])
+			if err != nil {
+				fail(err)
+			}
+			defer f.Close()
+			manifest = NewBatchManifest(f)
+		}
+
+		plan := NewPlanWriter(os.Stdout)
+		err := s3svc.WalkPlan(ctx, bucket, prefix, parseTimestamp(timestamp), func(version *s3.ObjectVersion, replaced string) error {
+			plan.Add(version)
+			if manifest != nil {
+				return manifest.Add(bucket, version)
+			}
+			return nil
+		})
+		stopProgress()
 		if err != nil {
-			log.Fatal(err)
+			fail(err)
+		}
+		plan.Close()
+
+		if manifest != nil {
+			if err := manifest.Close(); err != nil {
+				fail(err)
+			}
+		}
+		if args.Args["emit-batch-job"] != "" {
+			f, err := os.Create(args.Args["emit-batch-job"])
+			if err != nil {
+				fail(err)
+			}
+			defer f.Close()
+			err = WriteBatchJob(f, bucket, BatchJobOptions{
+				AccountId:   args.Args["batch-account-id"],
+				RoleArn:     args.Args["batch-role-arn"],
+				ManifestURL: args.Args["batch-manifest-url"],
+				ReportURL:   args.Args["batch-report-url"],
+			}, manifest.ETag())
+			if err != nil {
+				fail(err)
+			}
 		}
 
 	case "list":
-		log.Fatal("Not impleneted")
+		bucket := args.Args["bucket"]
+		prefix := args.Args["prefix"]
+
+		var since time.Time
+		if args.Args["since"] != "" {
+			since = parseTimestamp(args.Args["since"])
+		}
+
+		listVersionResp, err := s3svc.ListVersions(ctx, bucket, prefix)
+		if err != nil {
+			fail(err)
+		}
+		WriteVersions(os.Stdout, listVersionResp, since)
 	}
 }
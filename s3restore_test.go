@@ -2,6 +2,8 @@ package main_test
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os/exec"
@@ -58,12 +60,20 @@ var _ = Describe("S3restore", func() {
 			Expect(s3run.Err).To(gbytes.Say("timestamp"))
 		})
 
-		It("Doesn't implement list", func() {
+		It("Identifies rollback command", func() {
+			command := "rollback"
+			s3run := s3r(command, "-bucket", "mybucket")
+			Eventually(s3run).Should(gexec.Exit())
+			Expect(s3run.ExitCode()).To(Equal(2))
+			Expect(s3run.Err).To(gbytes.Say("-from"))
+		})
+
+		It("Identifies list command", func() {
 			command := "list"
 			s3run := s3r(command)
 			Eventually(s3run).Should(gexec.Exit())
 			Expect(s3run.ExitCode()).To(Equal(2))
-			Expect(s3run.Err).To(gbytes.Say("Not implemented"))
+			Expect(s3run.Err).To(gbytes.Say("since"))
 		})
 
 	})
@@ -103,6 +113,28 @@ var _ = Describe("S3restore", func() {
 
 })
 
+var _ = Describe("Version listing", func() {
+
+	It("Merges all pages", func() {
+		fake := newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{}
+		for i := 0; i < 1500; i++ {
+			key := fmt.Sprintf("key%04d", i)
+			fake.Listing.Versions = append(fake.Listing.Versions, version(key, "v"+key, 100, true))
+		}
+		fake.Listing.DeleteMarkers = []*s3.DeleteMarkerEntry{deleteMarker("key1499", "d", 200, true)}
+
+		versions, err := fake.S3svc().ListVersions(context.Background(), "mybucket", "key")
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fake.Calls["ListObjectVersions"]).To(Equal(2))
+		Expect(versions.Versions).To(HaveLen(1500))
+		Expect(*versions.Versions[1499].VersionId).To(Equal("vkey1499"))
+		Expect(versions.DeleteMarkers).To(HaveLen(1))
+	})
+
+})
+
 func restore(versions []*s3.ObjectVersion, time time.Time) (error, string) {
 	restoredVersion := ""
 	s := s3.New(unit.Session)
@@ -124,7 +156,7 @@ func restore(versions []*s3.ObjectVersion, time time.Time) (error, string) {
 
 	mockS3 := &S3svc{Svc: s}
 	testList := s3.ListObjectVersionsOutput{Versions: versions}
-	err := mockS3.RestoreObjects("mybucket", &testList, time)
+	err := mockS3.RestoreObjects(context.Background(), "mybucket", &testList, time)
 
 	return err, restoredVersion
 }
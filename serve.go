@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Gateway serves a read-only subset of the S3 API (ListObjectsV2, GetObject
+// and HeadObject) for a bucket as it was at a point in time. Requests must use
+// path-style addressing and aren't authenticated.
+type Gateway struct {
+	svc    *S3svc
+	bucket string
+	at     time.Time
+
+	keys  []string
+	state map[string]*s3.ObjectVersion
+}
+
+// IsLoopback reports whether address, as given to -listen, only accepts
+// connections from the same machine. An empty host listens on every
+// interface.
+func IsLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// NewServer returns a server for handler on address, with timeouts so that
+// slow or idle clients can't hold connections open for ever. Responses have
+// no deadline, as large objects take a while to download.
+func NewServer(address string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       time.Minute,
+		IdleTimeout:       2 * time.Minute,
+	}
+}
+
+// NewGateway lists the bucket's versions and works out what it looked like at
+// at. Later changes to the bucket aren't picked up.
+func NewGateway(svc *S3svc, bucket string, at time.Time) (*Gateway, error) {
+
+	versions, err := svc.ListVersions(context.Background(), bucket, "")
+	if err != nil {
+		return nil, err
+	}
+	state, err := svc.StateAt(context.Background(), bucket, versions, at)
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Gateway{svc: svc, bucket: bucket, at: at, state: state}
+	for key := range state {
+		g.keys = append(g.keys, key)
+	}
+	sort.Strings(g.keys)
+	return g, nil
+}
+
+type gatewayError struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string
+	Message  string
+	Resource string
+}
+
+func (g *Gateway) error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method != "HEAD" {
+		xml.NewEncoder(w).Encode(gatewayError{Code: code, Message: message, Resource: r.URL.Path})
+	}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		bucket, key = path[:i], path[i+1:]
+	}
+
+	if bucket != g.bucket {
+		g.error(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	if r.Method != "GET" && r.Method != "HEAD" {
+		g.error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", fmt.Sprintf("The bucket is read-only as of %d", g.at.Unix()))
+		return
+	}
+
+	if key == "" {
+		if r.Method == "HEAD" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.URL.Query().Get("list-type") != "2" {
+			g.error(w, r, http.StatusNotImplemented, "NotImplemented", "Only ListObjectsV2 is supported")
+			return
+		}
+		g.list(w, r)
+		return
+	}
+
+	version, ok := g.state[key]
+	if !ok {
+		g.error(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return
+	}
+	if r.Method == "HEAD" {
+		g.head(w, r, key, *version.VersionId)
+		return
+	}
+	g.get(w, r, key, *version.VersionId)
+}
+
+type gatewayObject struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type gatewayPrefix struct {
+	Prefix string
+}
+
+type gatewayListResult struct {
+	XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	KeyCount              int
+	MaxKeys               int
+	IsTruncated           bool
+	Contents              []gatewayObject
+	CommonPrefixes        []gatewayPrefix
+}
+
+func (g *Gateway) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	result := gatewayListResult{
+		Name:              g.bucket,
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		StartAfter:        query.Get("start-after"),
+		ContinuationToken: query.Get("continuation-token"),
+		MaxKeys:           1000,
+	}
+	if maxKeys := query.Get("max-keys"); maxKeys != "" {
+		n, err := strconv.Atoi(maxKeys)
+		if err != nil || n < 0 {
+			g.error(w, r, http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative integer")
+			return
+		}
+		if n < result.MaxKeys {
+			result.MaxKeys = n
+		}
+	}
+
+	// The continuation token is the last key or common prefix returned.
+	after := result.StartAfter
+	if result.ContinuationToken != "" {
+		token, err := base64.StdEncoding.DecodeString(result.ContinuationToken)
+		if err != nil {
+			g.error(w, r, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+			return
+		}
+		after = string(token)
+	}
+
+	last := ""
+	i := sort.SearchStrings(g.keys, after)
+	for ; i < len(g.keys); i++ {
+		key := g.keys[i]
+		if key <= after || !strings.HasPrefix(key, result.Prefix) {
+			continue
+		}
+		if result.Delimiter != "" {
+			if j := strings.Index(key[len(result.Prefix):], result.Delimiter); j >= 0 {
+				prefix := key[:len(result.Prefix)+j+len(result.Delimiter)]
+				if prefix == last || prefix == after {
+					continue
+				}
+				if result.KeyCount == result.MaxKeys {
+					result.IsTruncated = true
+					break
+				}
+				result.CommonPrefixes = append(result.CommonPrefixes, gatewayPrefix{Prefix: prefix})
+				result.KeyCount++
+				last = prefix
+				continue
+			}
+		}
+		if result.KeyCount == result.MaxKeys {
+			result.IsTruncated = true
+			break
+		}
+		version := g.state[key]
+		result.Contents = append(result.Contents, gatewayObject{
+			Key:          key,
+			LastModified: version.LastModified.UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         aws.StringValue(version.ETag),
+			Size:         aws.Int64Value(version.Size),
+			StorageClass: aws.StringValue(version.StorageClass),
+		})
+		result.KeyCount++
+		last = key
+	}
+	if result.IsTruncated {
+		result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(last))
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(result)
+}
+
+func setObjectHeaders(header http.Header, versionId string, contentType, etag *string, lastModified *time.Time, metadata map[string]*string) {
+	header.Set("x-amz-version-id", versionId)
+	if contentType != nil {
+		header.Set("Content-Type", *contentType)
+	}
+	if etag != nil {
+		header.Set("ETag", *etag)
+	}
+	if lastModified != nil {
+		header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	for key, value := range metadata {
+		if value != nil {
+			header.Set("x-amz-meta-"+key, *value)
+		}
+	}
+}
+
+func (g *Gateway) head(w http.ResponseWriter, r *http.Request, key, version string) {
+	head, err := g.svc.HeadVersion(r.Context(), g.bucket, key, version)
+	if err != nil {
+		g.upstreamError(w, r, err)
+		return
+	}
+	setObjectHeaders(w.Header(), version, head.ContentType, head.ETag, head.LastModified, head.Metadata)
+	if head.ContentLength != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(*head.ContentLength, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (g *Gateway) get(w http.ResponseWriter, r *http.Request, key, version string) {
+	getParams := &s3.GetObjectInput{
+		Bucket:    aws.String(g.bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(version),
+	}
+	if rng := r.Header.Get("Range"); rng != "" {
+		getParams.Range = aws.String(rng)
+	}
+	req, getResp := g.svc.Svc.GetObjectRequest(getParams)
+	if err := send(r.Context(), req); err != nil {
+		g.upstreamError(w, r, err)
+		return
+	}
+	defer getResp.Body.Close()
+
+	setObjectHeaders(w.Header(), version, getResp.ContentType, getResp.ETag, getResp.LastModified, getResp.Metadata)
+	if getResp.ContentLength != nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(*getResp.ContentLength, 10))
+	}
+	status := http.StatusOK
+	if getResp.ContentRange != nil {
+		w.Header().Set("Content-Range", *getResp.ContentRange)
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	io.Copy(w, getResp.Body)
+}
+
+func (g *Gateway) upstreamError(w http.ResponseWriter, r *http.Request, err error) {
+	if permErr, ok := err.(*PermissionError); ok {
+		err = permErr.Err
+	}
+	if reqErr, ok := err.(awserr.RequestFailure); ok {
+		g.error(w, r, reqErr.StatusCode(), reqErr.Code(), reqErr.Message())
+		return
+	}
+	g.error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+}
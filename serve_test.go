@@ -0,0 +1,128 @@
+package main_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/awstesting/unit"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Gateway", func() {
+	var (
+		fake   *fakeS3
+		server *httptest.Server
+		client *s3.S3
+	)
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("dir/a", "a2", 300, true),
+				version("dir/a", "a1", 100, false),
+				version("dir/b", "b1", 100, true),
+				version("gone", "g1", 100, false),
+				version("top", "t1", 100, true),
+			},
+			DeleteMarkers: []*s3.DeleteMarkerEntry{deleteMarker("gone", "gd", 150, true)},
+		}
+		fake.Heads["a1"] = http.Header{"Content-Type": []string{"text/plain"}, "Content-Length": []string{"4"}, "X-Amz-Meta-Writer": []string{"me"}}
+		fake.Bodies["a1"] = "old\n"
+
+		gateway, err := NewGateway(fake.S3svc(), "mybucket", time.Unix(200, 0))
+		Expect(err).ToNot(HaveOccurred())
+		server = httptest.NewServer(gateway)
+		client = s3.New(unit.Session, &aws.Config{
+			Endpoint:         aws.String(server.URL),
+			S3ForcePathStyle: aws.Bool(true),
+		})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("Lists objects as they were", func() {
+		list, err := client.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String("mybucket")})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(list.Contents).To(HaveLen(3))
+		Expect(*list.Contents[0].Key).To(Equal("dir/a"))
+		Expect(*list.Contents[0].LastModified).To(Equal(time.Unix(100, 0).UTC()))
+		Expect(*list.Contents[2].Key).To(Equal("top"))
+	})
+
+	It("Pages through common prefixes and keys", func() {
+		var keys []string
+		err := client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+			Bucket:    aws.String("mybucket"),
+			Delimiter: aws.String("/"),
+			MaxKeys:   aws.Int64(1),
+		}, func(page *s3.ListObjectsV2Output, last bool) bool {
+			for _, prefix := range page.CommonPrefixes {
+				keys = append(keys, *prefix.Prefix)
+			}
+			for _, object := range page.Contents {
+				keys = append(keys, *object.Key)
+			}
+			return true
+		})
+
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keys).To(Equal([]string{"dir/", "top"}))
+	})
+
+	It("Gets and heads the version at the point in time", func() {
+		get, err := client.GetObject(&s3.GetObjectInput{Bucket: aws.String("mybucket"), Key: aws.String("dir/a")})
+		Expect(err).ToNot(HaveOccurred())
+		body, _ := ioutil.ReadAll(get.Body)
+		Expect(string(body)).To(Equal("old\n"))
+		Expect(*get.VersionId).To(Equal("a1"))
+
+		head, err := client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String("mybucket"), Key: aws.String("dir/a")})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*head.ContentType).To(Equal("text/plain"))
+		Expect(*head.ContentLength).To(Equal(int64(4)))
+		Expect(*head.Metadata["Writer"]).To(Equal("me"))
+	})
+
+	It("Doesn't serve deleted keys, other buckets or writes", func() {
+		_, err := client.GetObject(&s3.GetObjectInput{Bucket: aws.String("mybucket"), Key: aws.String("gone")})
+		Expect(err.(awserr.Error).Code()).To(Equal("NoSuchKey"))
+
+		_, err = client.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String("other")})
+		Expect(err.(awserr.Error).Code()).To(Equal("NoSuchBucket"))
+
+		_, err = client.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String("mybucket"), Key: aws.String("top")})
+		Expect(err.(awserr.Error).Code()).To(Equal("MethodNotAllowed"))
+		Expect(fake.Deleted).To(BeEmpty())
+	})
+
+	It("Knows which addresses only accept local connections", func() {
+		Expect(IsLoopback("127.0.0.1:9000")).To(BeTrue())
+		Expect(IsLoopback("localhost:9000")).To(BeTrue())
+		Expect(IsLoopback("[::1]:9000")).To(BeTrue())
+		Expect(IsLoopback(":9000")).To(BeFalse())
+		Expect(IsLoopback("0.0.0.0:9000")).To(BeFalse())
+		Expect(IsLoopback("10.0.0.1:9000")).To(BeFalse())
+	})
+
+	It("Doesn't wait for slow or idle clients for ever", func() {
+		server := NewServer("127.0.0.1:9000", server.Config.Handler)
+
+		Expect(server.Addr).To(Equal("127.0.0.1:9000"))
+		Expect(server.ReadHeaderTimeout).To(BeNumerically(">", 0))
+		Expect(server.ReadTimeout).To(BeNumerically(">", 0))
+		Expect(server.IdleTimeout).To(BeNumerically(">", 0))
+	})
+
+})
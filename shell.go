@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const shellHelp = `Commands:
+ ls [path]              List objects as they were at the current time
+ cd <path>              Change directory
+ pwd                    Print the current directory
+ cat <path>             Print an object
+ history <path>         Show every version of an object
+ diff <path> [time]     Show changes to an object since the current time, or up to time
+ at <time>              Change the current time, in UNIX timestamp format
+ restore <path>         Restore an object, or every object under a directory
+ refresh                List the bucket again
+ exit                   Leave the shell
+`
+
+// Shell browses a bucket as it was at a point in time. The bucket's versions
+// are listed once and kept in memory until refreshed.
+type Shell struct {
+	svc    *S3svc
+	bucket string
+	at     time.Time
+	cwd    string
+	out    io.Writer
+
+	versions *s3.ListObjectVersionsOutput
+	state    map[string]*s3.ObjectVersion
+}
+
+func NewShell(svc *S3svc, bucket string, at time.Time, out io.Writer) *Shell {
+	return &Shell{svc: svc, bucket: bucket, at: at, out: out}
+}
+
+func (sh *Shell) refresh() error {
+	versions, err := sh.svc.ListVersions(context.Background(), sh.bucket, "")
+	if err != nil {
+		return err
+	}
+	sh.versions = versions
+	return sh.travel(sh.at)
+}
+
+func (sh *Shell) travel(at time.Time) error {
+	state, err := sh.svc.StateAt(context.Background(), sh.bucket, sh.versions, at)
+	if err != nil {
+		return err
+	}
+	sh.at, sh.state = at, state
+	return nil
+}
+
+// resolve turns a path relative to the current directory into a key.
+// Directories end with a slash, the root is empty.
+func (sh *Shell) resolve(p string) string {
+	dir := strings.HasSuffix(p, "/") || p == "." || p == ".." || strings.HasSuffix(p, "/..") || strings.HasSuffix(p, "/.")
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + sh.cwd + p
+	}
+	p = strings.TrimPrefix(path.Clean(p), "/")
+	if p != "" && dir {
+		p += "/"
+	}
+	return p
+}
+
+func (sh *Shell) version(key string) (*s3.ObjectVersion, error) {
+	version, ok := sh.state[key]
+	if !ok {
+		return nil, fmt.Errorf("%s didn't exist at %d", key, sh.at.Unix())
+	}
+	return version, nil
+}
+
+func (sh *Shell) ls(dir string) {
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		if version, ok := sh.state[dir]; ok {
+			fmt.Fprintf(sh.out, "%12d  %s  %s\n", *version.Size, version.LastModified.UTC().Format(time.RFC3339), path.Base(dir))
+			return
+		}
+		dir += "/"
+	}
+
+	dirs := make(map[string]bool)
+	var names []string
+	for key := range sh.state {
+		if !strings.HasPrefix(key, dir) {
+			continue
+		}
+		name := strings.TrimPrefix(key, dir)
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[:i+1]
+			if dirs[name] {
+				continue
+			}
+			dirs[name] = true
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if dirs[name] {
+			fmt.Fprintf(sh.out, "%12s  %20s  %s\n", "DIR", "", name)
+			continue
+		}
+		version := sh.state[dir+name]
+		fmt.Fprintf(sh.out, "%12d  %s  %s\n", *version.Size, version.LastModified.UTC().Format(time.RFC3339), name)
+	}
+}
+
+// Execute runs a single shell command.
+func (sh *Shell) Execute(line string) error {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return nil
+	}
+	if sh.versions == nil {
+		if err := sh.refresh(); err != nil {
+			return err
+		}
+	}
+
+	needs := func(n int) error {
+		if len(args) < n+1 {
+			return fmt.Errorf("usage: %s", shellUsage(args[0]))
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "help":
+		fmt.Fprint(sh.out, shellHelp)
+
+	case "pwd":
+		fmt.Fprintf(sh.out, "/%s\n", sh.cwd)
+
+	case "ls":
+		dir := sh.cwd
+		if len(args) > 1 {
+			dir = sh.resolve(args[1])
+		}
+		sh.ls(dir)
+
+	case "cd":
+		if err := needs(1); err != nil {
+			return err
+		}
+		dir := sh.resolve(args[1])
+		if dir != "" && !strings.HasSuffix(dir, "/") {
+			dir += "/"
+		}
+		sh.cwd = dir
+
+	case "cat":
+		if err := needs(1); err != nil {
+			return err
+		}
+		key := sh.resolve(args[1])
+		version, err := sh.version(key)
+		if err != nil {
+			return err
+		}
+		return sh.svc.CatVersion(context.Background(), sh.out, sh.bucket, key, *version.VersionId)
+
+	case "history":
+		if err := needs(1); err != nil {
+			return err
+		}
+		key := sh.resolve(args[1])
+		entries, err := sh.svc.KeyHistory(context.Background(), sh.bucket, key, sh.versions, sh.at, "")
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return fmt.Errorf("%s has no versions", key)
+		}
+		WriteHistory(sh.out, entries)
+
+	case "diff":
+		if err := needs(1); err != nil {
+			return err
+		}
+		key := sh.resolve(args[1])
+		from, err := sh.version(key)
+		if err != nil {
+			return err
+		}
+		to := time.Now()
+		if len(args) > 2 {
+			if to, err = parseShellTime(args[2]); err != nil {
+				return err
+			}
+		}
+		state, err := sh.svc.StateAt(context.Background(), sh.bucket, sh.versions, to)
+		if err != nil {
+			return err
+		}
+		version, ok := state[key]
+		if !ok {
+			return fmt.Errorf("%s didn't exist at %d", key, to.Unix())
+		}
+		return sh.svc.ContentDiff(context.Background(), sh.out, sh.bucket, key, *from.VersionId, *version.VersionId, 1<<20)
+
+	case "at":
+		if err := needs(1); err != nil {
+			return err
+		}
+		at, err := parseShellTime(args[1])
+		if err != nil {
+			return err
+		}
+		return sh.travel(at)
+
+	case "restore":
+		if err := needs(1); err != nil {
+			return err
+		}
+		// Only what ls shows is restored, so keys deleted by the current
+		// time stay deleted.
+		target := sh.resolve(args[1])
+		isDir := target == "" || strings.HasSuffix(target, "/")
+		selected := &s3.ListObjectVersionsOutput{Prefix: aws.String(target)}
+		for key, version := range sh.state {
+			if key == target || isDir && strings.HasPrefix(key, target) {
+				selected.Versions = append(selected.Versions, version)
+			}
+		}
+		if len(selected.Versions) == 0 {
+			return fmt.Errorf("%s didn't exist at %d", target, sh.at.Unix())
+		}
+		sort.Slice(selected.Versions, func(i, j int) bool {
+			return *selected.Versions[i].Key < *selected.Versions[j].Key
+		})
+		if err := sh.svc.CheckVersioning(context.Background(), sh.bucket); err != nil {
+			return err
+		}
+		if err := sh.svc.RestoreObjects(context.Background(), sh.bucket, selected, sh.at); err != nil {
+			return err
+		}
+		return sh.refresh()
+
+	case "refresh":
+		return sh.refresh()
+
+	default:
+		return fmt.Errorf("%q is not valid command. Try help", args[0])
+	}
+	return nil
+}
+
+func shellUsage(command string) string {
+	for _, line := range strings.Split(shellHelp, "\n") {
+		if strings.HasPrefix(line, " "+command+" ") {
+			return strings.SplitN(strings.TrimSpace(line), "  ", 2)[0]
+		}
+	}
+	return command
+}
+
+func parseShellTime(timestamp string) (time.Time, error) {
+	i, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a UNIX timestamp", timestamp)
+	}
+	return time.Unix(i, 0), nil
+}
+
+// Run reads commands from in until it ends or exit is given. Failing commands
+// are reported and don't end the shell. If restores must be confirmed, the
+// answer is read from in too.
+func (sh *Shell) Run(in io.Reader) error {
+	if err := sh.refresh(); err != nil {
+		return err
+	}
+
+	// Prompt reads through the same buffer, so answers aren't taken for
+	// commands or the other way round.
+	reader := bufio.NewReader(in)
+	if sh.svc.Confirm != nil {
+		sh.svc.Confirm = Prompt(reader, sh.out)
+	}
+	for {
+		fmt.Fprintf(sh.out, "%s:/%s@%d> ", sh.bucket, sh.cwd, sh.at.Unix())
+		line, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			fmt.Fprintln(sh.out)
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "exit" || line == "quit" {
+			return nil
+		}
+		if err := sh.Execute(line); err != nil {
+			fmt.Fprintf(sh.out, "error: %s\n", err)
+		}
+	}
+}
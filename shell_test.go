@@ -0,0 +1,108 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Shell", func() {
+	var (
+		fake  *fakeS3
+		shell *Shell
+		out   bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				version("top", "t1", 100, true),
+				version("dir/a", "a2", 300, true),
+				version("dir/a", "a1", 100, false),
+				version("dir/sub/b", "b1", 100, true),
+				version("dir/new", "n1", 300, true),
+			},
+		}
+		fake.Bodies["a1"] = "old\n"
+		fake.Bodies["a2"] = "new\n"
+		out.Reset()
+		shell = NewShell(fake.S3svc(), "mybucket", time.Unix(200, 0), &out)
+	})
+
+	It("Lists and changes directories as of the current time", func() {
+		Expect(shell.Execute("ls")).To(Succeed())
+		Expect(out.String()).To(MatchRegexp(`^\s+DIR\s+dir/\n\s+10  1970-01-01T00:01:40Z  top\n$`))
+
+		out.Reset()
+		Expect(shell.Execute("cd dir")).To(Succeed())
+		Expect(shell.Execute("ls")).To(Succeed())
+		Expect(out.String()).To(MatchRegexp(`^\s+10  1970-01-01T00:01:40Z  a\n\s+DIR\s+sub/\n$`))
+
+		out.Reset()
+		Expect(shell.Execute("cd sub/..")).To(Succeed())
+		Expect(shell.Execute("pwd")).To(Succeed())
+		Expect(out.String()).To(Equal("/dir/\n"))
+	})
+
+	It("Prints objects and diffs them against other times", func() {
+		Expect(shell.Execute("cat /dir/a")).To(Succeed())
+		Expect(out.String()).To(Equal("old\n"))
+
+		out.Reset()
+		Expect(shell.Execute("diff dir/a")).To(Succeed())
+		Expect(out.String()).To(ContainSubstring("-old\n+new\n"))
+
+		Expect(shell.Execute("cat dir/new")).To(MatchError("dir/new didn't exist at 200"))
+	})
+
+	It("Travels in time", func() {
+		Expect(shell.Execute("at 400")).To(Succeed())
+		Expect(shell.Execute("cat dir/new")).To(Succeed())
+		Expect(shell.Execute("at yesterday")).To(MatchError(ContainSubstring("not a UNIX timestamp")))
+	})
+
+	It("Restores objects under a path", func() {
+		Expect(shell.Execute("restore dir/")).To(Succeed())
+		Expect(fake.Copied).To(Equal([]string{"a1"}))
+		Expect(fake.Calls["ListObjectVersions"]).To(Equal(2))
+	})
+
+	It("Restores only what it shows, leaving keys deleted by then alone", func() {
+		fake.Listing.Versions = append(fake.Listing.Versions, version("gone/c", "c1", 100, false))
+		fake.Listing.DeleteMarkers = []*s3.DeleteMarkerEntry{deleteMarker("gone/c", "cd", 150, true)}
+		Expect(shell.Execute("refresh")).To(Succeed())
+
+		Expect(shell.Execute("ls gone/")).To(Succeed())
+		Expect(out.String()).To(BeEmpty())
+		Expect(shell.Execute("restore gone/")).To(MatchError("gone/ didn't exist at 200"))
+		Expect(fake.Copied).To(BeEmpty())
+	})
+
+	It("Asks before restoring, reading the answer from its input", func() {
+		svc := fake.S3svc()
+		svc.Confirm = func(PlanSummary) (bool, error) { return true, nil }
+		shell = NewShell(svc, "mybucket", time.Unix(200, 0), &out)
+
+		Expect(shell.Run(strings.NewReader("restore dir/\nn\nrestore dir/\ny\n"))).To(Succeed())
+		question := "Restore s3://mybucket/dir/ to 1970-01-01T00:03:20Z: 1 objects, 10 B to copy\nContinue? [y/N] "
+		Expect(out.String()).To(Equal("mybucket:/@200> " + question + "error: aborted: not confirmed\n" +
+			"mybucket:/@200> " + question + "mybucket:/@200> \n"))
+		Expect(fake.Copied).To(Equal([]string{"a1"}))
+
+		fake.Versioning = "Suspended"
+		Expect(shell.Execute("restore dir/")).To(BeAssignableToTypeOf(&NotVersionedError{}))
+	})
+
+	It("Runs commands until exit and reports errors", func() {
+		Expect(shell.Run(strings.NewReader("cd\nnope\nexit\nls\n"))).To(Succeed())
+		Expect(out.String()).To(Equal("mybucket:/@200> error: usage: cd <path>\nmybucket:/@200> error: \"nope\" is not valid command. Try help\nmybucket:/@200> "))
+	})
+
+})
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket allowing Rate units a second on average, in
+// bursts of up to a second's worth. Taking more than is available is allowed,
+// e.g. for an object bigger than a second's worth of bytes, and later takers
+// wait for the debt to be paid off.
+type RateLimit struct {
+	Rate float64
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimit(rate float64) *RateLimit {
+	return &RateLimit{Rate: rate, tokens: rate, last: time.Now()}
+}
+
+// Take waits until n units may be used.
+func (r *RateLimit) Take(n float64) {
+	r.lock.Lock()
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.Rate
+	if r.tokens > r.Rate {
+		r.tokens = r.Rate
+	}
+	r.last = now
+	r.tokens -= n
+	wait := time.Duration(-r.tokens / r.Rate * float64(time.Second))
+	r.lock.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// PrefixRates limits something overall and under particular prefixes. A key
+// is held to the overall limit and to the limit of the longest prefix it's
+// under. A nil PrefixRates doesn't limit anything.
+type PrefixRates struct {
+	global   *RateLimit
+	prefixes []string
+	limits   map[string]*RateLimit
+}
+
+// ParseRates parses comma-separated rates, either a number for the overall
+// limit or prefix=number for the limit of keys under prefix.
+func ParseRates(spec string) (*PrefixRates, error) {
+
+	items := splitList(spec)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	p := &PrefixRates{limits: make(map[string]*RateLimit)}
+	for _, item := range items {
+		prefix, value := "", item
+		if strings.Contains(item, "=") {
+			var err error
+			if prefix, value, err = parseKeyValue(item); err != nil {
+				return nil, err
+			}
+		}
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("%q is not a positive rate", value)
+		}
+		if prefix == "" {
+			p.global = NewRateLimit(rate)
+			continue
+		}
+		p.prefixes = append(p.prefixes, prefix)
+		p.limits[prefix] = NewRateLimit(rate)
+	}
+
+	// Longest first, so the first match is the most specific.
+	sort.SliceStable(p.prefixes, func(i, j int) bool {
+		return len(p.prefixes[i]) > len(p.prefixes[j])
+	})
+	return p, nil
+}
+
+// Take waits until n units may be used for key.
+func (p *PrefixRates) Take(key string, n float64) {
+	if p == nil {
+		return
+	}
+	if p.global != nil {
+		p.global.Take(n)
+	}
+	for _, prefix := range p.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			p.limits[prefix].Take(n)
+			return
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ = Describe("Rate limits", func() {
+
+	elapsed := func(f func()) time.Duration {
+		start := time.Now()
+		f()
+		return time.Since(start)
+	}
+
+	It("Allows a second's worth at once, then waits", func() {
+		limit := NewRateLimit(50)
+
+		Expect(elapsed(func() { limit.Take(50) })).To(BeNumerically("<", 50*time.Millisecond))
+		Expect(elapsed(func() { limit.Take(10) })).To(BeNumerically("~", 200*time.Millisecond, 100*time.Millisecond))
+	})
+
+	It("Lets takers go into debt", func() {
+		limit := NewRateLimit(100)
+
+		Expect(elapsed(func() { limit.Take(120) })).To(BeNumerically("~", 200*time.Millisecond, 100*time.Millisecond))
+	})
+
+	It("Parses overall and per prefix limits", func() {
+		rates, err := ParseRates("100, logs/=10,logs/2026/=5")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rates).ToNot(BeNil())
+
+		rates, err = ParseRates("")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rates).To(BeNil())
+		rates.Take("anything", 1000)
+
+		_, err = ParseRates("logs/=fast")
+		Expect(err).To(MatchError(`"fast" is not a positive rate`))
+		_, err = ParseRates("=10")
+		Expect(err).To(MatchError(`"=10" is not in key=value format`))
+		_, err = ParseRates("0")
+		Expect(err).To(MatchError(`"0" is not a positive rate`))
+	})
+
+	It("Holds keys to the limit of the longest prefix they're under", func() {
+		rates, err := ParseRates("logs/=1000,logs/2026/=10")
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(elapsed(func() { rates.Take("logs/2025/a", 500) })).To(BeNumerically("<", 50*time.Millisecond))
+		Expect(elapsed(func() { rates.Take("logs/2026/a", 12) })).To(BeNumerically("~", 200*time.Millisecond, 100*time.Millisecond))
+		Expect(elapsed(func() { rates.Take("other", 1000000) })).To(BeNumerically("<", 50*time.Millisecond))
+	})
+
+	It("Slows down copies", func() {
+		fake := newFakeS3()
+		fake.Listing = &s3.ListObjectVersionsOutput{}
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("slow/%d", i)
+			fake.Listing.Versions = append(fake.Listing.Versions, version(key, key+"-2", 200, true), version(key, key+"-1", 100, false))
+		}
+		svc := fake.S3svc()
+		svc.Limiter.SetMax(4)
+		svc.RequestRate, _ = ParseRates("slow/=100")
+		svc.ByteRate, _ = ParseRates("40")
+
+		took := elapsed(func() {
+			Expect(svc.RestorePrefix(context.Background(), "mybucket", "", time.Unix(150, 0))).To(Succeed())
+		})
+
+		// 10 copies of 10 bytes, 40 at once then 40 a second.
+		Expect(fake.Copied).To(HaveLen(10))
+		Expect(took).To(BeNumerically("~", 1500*time.Millisecond, 300*time.Millisecond))
+	})
+})
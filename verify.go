@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Mismatch describes a restored version whose content differs from the
+// version it was copied from.
+type Mismatch struct {
+	Key          string
+	VersionId    string
+	NewVersionId string
+	Reason       string
+}
+
+// etagIsMD5 reports whether the ETag of an object is the MD5 of its content,
+// which isn't the case for multipart uploads and KMS or customer key
+// encryption.
+func etagIsMD5(head *s3.HeadObjectOutput) bool {
+	if head.ETag == nil || strings.Contains(*head.ETag, "-") {
+		return false
+	}
+	if head.SSECustomerAlgorithm != nil {
+		return false
+	}
+	return head.ServerSideEncryption == nil || *head.ServerSideEncryption != s3.ServerSideEncryptionAwsKms
+}
+
+func (s *S3svc) hashVersion(ctx context.Context, bucket, key, version string) ([]byte, error) {
+	getResp, err := s.GetVersion(ctx, bucket, key, version)
+	if err != nil {
+		return nil, err
+	}
+	defer getResp.Body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, getResp.Body); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// VerifyCopy compares the content of newVersion of key with version it was
+// copied from. Size and ETag are compared where possible. Where the ETags
+// don't reflect the content, both versions are downloaded and their SHA-256
+// compared instead.
+func (s *S3svc) VerifyCopy(ctx context.Context, bucket, key, version, newVersion string) (*Mismatch, error) {
+
+	mismatch := func(reason string) *Mismatch {
+		return &Mismatch{Key: key, VersionId: version, NewVersionId: newVersion, Reason: reason}
+	}
+
+	source, err := s.HeadVersion(ctx, bucket, key, version)
+	if err != nil {
+		return nil, err
+	}
+	restored, err := s.HeadVersion(ctx, bucket, key, newVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if source.ContentLength != nil && restored.ContentLength != nil && *source.ContentLength != *restored.ContentLength {
+		return mismatch(fmt.Sprintf("size %d, expected %d", *restored.ContentLength, *source.ContentLength)), nil
+	}
+
+	if etagIsMD5(source) && etagIsMD5(restored) {
+		if *source.ETag != *restored.ETag {
+			return mismatch(fmt.Sprintf("ETag %s, expected %s", *restored.ETag, *source.ETag)), nil
+		}
+		return nil, nil
+	}
+
+	sourceHash, err := s.hashVersion(ctx, bucket, key, version)
+	if err != nil {
+		return nil, err
+	}
+	restoredHash, err := s.hashVersion(ctx, bucket, key, newVersion)
+	if err != nil {
+		return nil, err
+	}
+	if string(sourceHash) != string(restoredHash) {
+		return mismatch(fmt.Sprintf("SHA-256 %x, expected %x", restoredHash, sourceHash)), nil
+	}
+	return nil, nil
+}
+
+// copyVersion restores version of key, size bytes long, counting it in
+// Metrics, and, if Verify is set, checks the result. Mismatches are collected
+// in Mismatches rather than failing the restore, so the remaining keys still
+// get restored.
+func (s *S3svc) copyVersion(ctx context.Context, bucket, key, version string, size int64) (*s3.CopyObjectOutput, error) {
+
+	s.Log.Debug("restoring", "action", "restore", "bucket", bucket, "key", key, "version_id", version)
+	start := time.Now()
+	// Copies aren't abandoned once started, even when stopping, so none is
+	// left not knowing whether it happened.
+	copyResp, err := s.CopyObject(context.Background(), bucket, key, version)
+	s.Metrics.ObserveCopy(size, time.Since(start), err)
+	if err != nil {
+		return nil, err
+	}
+	s.Log.Info("restored", "action", "restore", "bucket", bucket, "key", key, "version_id", version,
+		"new_version_id", aws.StringValue(copyResp.VersionId), "duration", time.Since(start))
+	if !s.Verify {
+		return copyResp, nil
+	}
+	if copyResp.VersionId == nil {
+		return nil, &NotVersionedError{Bucket: bucket}
+	}
+
+	mismatch, err := s.VerifyCopy(ctx, bucket, key, version, *copyResp.VersionId)
+	if err != nil {
+		return nil, err
+	}
+	if mismatch != nil {
+		s.Log.Warn("restored version doesn't match", "action", "verify", "bucket", bucket, "key", key, "version_id", version,
+			"new_version_id", *copyResp.VersionId, "error", mismatch.Reason)
+		s.resultLock.Lock()
+		s.Mismatches = append(s.Mismatches, *mismatch)
+		s.resultLock.Unlock()
+	}
+	return copyResp, nil
+}
+
+// Difference describes a key whose current state doesn't match the state it
+// had at a point in time. Status is one of "differs", "missing" or "extra".
+type Difference struct {
+	Key               string
+	Status            string
+	ExpectedVersionId string
+	CurrentVersionId  string
+	Reason            string
+}
+
+// Mismatched returns the differences which keep a bucket from matching, those
+// of keys which differ or are missing. Keys created since are extra, but left
+// alone by restore, so a bucket just restored still matches.
+func Mismatched(differences []Difference) []Difference {
+	var mismatched []Difference
+	for _, difference := range differences {
+		if difference.Status != "extra" {
+			mismatched = append(mismatched, difference)
+		}
+	}
+	return mismatched
+}
+
+func (s *S3svc) mismatchCount() int {
+	s.resultLock.Lock()
+	defer s.resultLock.Unlock()
+	return len(s.Mismatches)
+}
+
+// verificationError returns a VerificationError if any mismatches were found
+// after the first n.
+func (s *S3svc) verificationError(n int) error {
+	s.resultLock.Lock()
+	defer s.resultLock.Unlock()
+	if len(s.Mismatches) > n {
+		return &VerificationError{Mismatches: s.Mismatches[n:]}
+	}
+	return nil
+}
+
+// VerifyBucket compares the latest versions in the listing with the versions
+// RestoreObjects would restore for restoreTime. It doesn't change anything,
+// so it can be used to check a restore or to rehearse one. Once ctx is done no
+// more keys are compared and a CanceledError is returned.
+func (s *S3svc) VerifyBucket(ctx context.Context, bucket string, versions *s3.ListObjectVersionsOutput, restoreTime time.Time) ([]Difference, error) {
+
+	selected, err := s.SelectVersions(ctx, bucket, versions, restoreTime)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]*s3.ObjectVersion)
+	for _, version := range versions.Versions {
+		if *version.IsLatest {
+			current[*version.Key] = version
+		}
+	}
+
+	var differences []Difference
+	expected := make(map[string]bool)
+	for _, version := range selected {
+		if err := canceled(ctx); err != nil {
+			return nil, err
+		}
+		key := *version.Key
+		expected[key] = true
+
+		latest, ok := current[key]
+		if !ok {
+			differences = append(differences, Difference{
+				Key:               key,
+				Status:            "missing",
+				ExpectedVersionId: *version.VersionId,
+			})
+			continue
+		}
+		if *latest.VersionId == *version.VersionId {
+			continue
+		}
+		// A restored key has a new version, copied from the expected one.
+		if version.ETag != nil && latest.ETag != nil && *version.ETag == *latest.ETag &&
+			version.Size != nil && latest.Size != nil && *version.Size == *latest.Size {
+			continue
+		}
+		if version.Size != nil && latest.Size != nil && *version.Size != *latest.Size {
+			differences = append(differences, Difference{
+				Key:               key,
+				Status:            "differs",
+				ExpectedVersionId: *version.VersionId,
+				CurrentVersionId:  *latest.VersionId,
+				Reason:            fmt.Sprintf("size %d, expected %d", *latest.Size, *version.Size),
+			})
+			continue
+		}
+
+		mismatch, err := s.VerifyCopy(ctx, bucket, key, *version.VersionId, *latest.VersionId)
+		if err != nil {
+			return nil, err
+		}
+		if mismatch != nil {
+			differences = append(differences, Difference{
+				Key:               key,
+				Status:            "differs",
+				ExpectedVersionId: *version.VersionId,
+				CurrentVersionId:  *latest.VersionId,
+				Reason:            mismatch.Reason,
+			})
+		}
+	}
+
+	for _, version := range versions.Versions {
+		if *version.IsLatest && !expected[*version.Key] {
+			differences = append(differences, Difference{
+				Key:              *version.Key,
+				Status:           "extra",
+				CurrentVersionId: *version.VersionId,
+			})
+		}
+	}
+
+	sort.Slice(differences, func(i, j int) bool {
+		return differences[i].Key < differences[j].Key
+	})
+	return differences, nil
+}
@@ -0,0 +1,134 @@
+package main_test
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	. "github.com/alphagov/paas-s3restore"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func head(size, etag string) http.Header {
+	return http.Header{"Content-Length": []string{size}, "Etag": []string{etag}}
+}
+
+var _ = Describe("Restore verification", func() {
+	var (
+		fake     *fakeS3
+		svc      *S3svc
+		versions *s3.ListObjectVersionsOutput
+	)
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+		fake.Heads["v2"] = head("5", `"5a105e8b9d40e1329780d62ea2265d8a"`)
+		fake.Bodies["v2"] = "test2"
+
+		svc = fake.S3svc()
+		svc.Verify = true
+		versions = &s3.ListObjectVersionsOutput{Versions: defaultVersions()}
+	})
+
+	It("Accepts copies with matching size and ETag", func() {
+		Expect(svc.RestoreObjects(context.Background(), "mybucket", versions, time.Unix(250, 0))).To(Succeed())
+		Expect(svc.Mismatches).To(BeEmpty())
+		Expect(fake.Calls["GetObject"]).To(Equal(0))
+	})
+
+	It("Reports copies with a different size", func() {
+		fake.Heads["copy-of-v2"] = head("4", `"5a105e8b9d40e1329780d62ea2265d8a"`)
+
+		err := svc.RestoreObjects(context.Background(), "mybucket", versions, time.Unix(250, 0))
+		Expect(err).To(BeAssignableToTypeOf(&VerificationError{}))
+		Expect(ExitCode(err)).To(Equal(ExitMismatch))
+		Expect(svc.Mismatches).To(Equal([]Mismatch{
+			{Key: "a", VersionId: "v2", NewVersionId: "copy-of-v2", Reason: "size 4, expected 5"},
+		}))
+	})
+
+	It("Compares content of multipart uploads", func() {
+		fake.Heads["v2"] = head("5", `"d41d8cd98f00b204e9800998ecf8427e-2"`)
+		fake.Heads["copy-of-v2"] = head("5", `"5a105e8b9d40e1329780d62ea2265d8a"`)
+
+		Expect(svc.RestoreObjects(context.Background(), "mybucket", versions, time.Unix(250, 0))).To(Succeed())
+		Expect(svc.Mismatches).To(BeEmpty())
+		Expect(fake.Calls["GetObject"]).To(Equal(2))
+	})
+
+	It("Reports encrypted copies with different content", func() {
+		fake.Heads["v2"].Set("X-Amz-Server-Side-Encryption", "aws:kms")
+		fake.Bodies["copy-of-v2"] = "TEST2"
+
+		err := svc.RestoreObjects(context.Background(), "mybucket", versions, time.Unix(250, 0))
+		Expect(err).To(MatchError("1 restored objects don't match"))
+		Expect(svc.Mismatches).To(HaveLen(1))
+		Expect(svc.Mismatches[0].Reason).To(HavePrefix("SHA-256"))
+	})
+
+})
+
+var _ = Describe("Bucket verification", func() {
+	var fake *fakeS3
+
+	BeforeEach(func() {
+		fake = newFakeS3()
+	})
+
+	withETag := func(v *s3.ObjectVersion, etag string) *s3.ObjectVersion {
+		v.ETag = aws.String(etag)
+		return v
+	}
+
+	It("Matches when the latest versions are the expected ones or copies of them", func() {
+		versions := &s3.ListObjectVersionsOutput{Versions: []*s3.ObjectVersion{
+			withETag(version("a", "a3", 300, true), `"one"`),
+			withETag(version("a", "a2", 200, false), `"two"`),
+			withETag(version("a", "a1", 100, false), `"one"`),
+			version("b", "b1", 100, true),
+		}}
+
+		differences, err := fake.S3svc().VerifyBucket(context.Background(), "mybucket", versions, time.Unix(150, 0))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(differences).To(BeEmpty())
+		Expect(fake.Calls).To(BeEmpty())
+	})
+
+	It("Reports keys that differ, are missing or are extra", func() {
+		fake.Heads["a1"] = head("10", `"one"`)
+		fake.Heads["a2"] = head("10", `"two"`)
+		versions := &s3.ListObjectVersionsOutput{
+			Versions: []*s3.ObjectVersion{
+				withETag(version("a", "a2", 200, true), `"two"`),
+				withETag(version("a", "a1", 100, false), `"one"`),
+				version("b", "b1", 100, false),
+				version("c", "c1", 200, true),
+			},
+			DeleteMarkers: []*s3.DeleteMarkerEntry{deleteMarker("b", "d1", 200, true)},
+		}
+
+		differences, err := fake.S3svc().VerifyBucket(context.Background(), "mybucket", versions, time.Unix(150, 0))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(differences).To(Equal([]Difference{
+			{Key: "a", Status: "differs", ExpectedVersionId: "a1", CurrentVersionId: "a2", Reason: `ETag "two", expected "one"`},
+			{Key: "b", Status: "missing", ExpectedVersionId: "b1"},
+			{Key: "c", Status: "extra", CurrentVersionId: "c1"},
+		}))
+	})
+
+	It("Doesn't count keys created since as mismatches", func() {
+		differences := []Difference{
+			{Key: "a", Status: "differs", ExpectedVersionId: "a1", CurrentVersionId: "a2"},
+			{Key: "b", Status: "missing", ExpectedVersionId: "b1"},
+			{Key: "c", Status: "extra", CurrentVersionId: "c1"},
+		}
+
+		Expect(Mismatched(differences)).To(Equal(differences[:2]))
+		Expect(Mismatched(differences[2:])).To(BeEmpty())
+	})
+
+})